@@ -2,10 +2,22 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 
+	"github.com/sbrki/monkey/pkg/evaluator"
 	"github.com/sbrki/monkey/pkg/repl"
 )
 
 func main() {
+	// MONKEYPATH lists the directories to look up imported files in
+	evaluator.SearchPath = filepath.SplitList(os.Getenv("MONKEYPATH"))
+
+	if len(os.Args) > 1 {
+		if !repl.Run(os.Args[1], os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	repl.Start(os.Stdin, os.Stdout)
 }
@@ -2,7 +2,10 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sbrki/monkey/pkg/token"
 )
@@ -10,6 +13,8 @@ import (
 type Node interface {
 	TokenLiteral() string // used only for testing
 	String() string
+	Pos() token.Position // position of the first character of the node
+	End() token.Position // position immediately after the node
 }
 
 type Statement interface {
@@ -22,6 +27,14 @@ type Expression interface {
 	isExpressionNode() // dummy for catching errors at compile time
 }
 
+// Pattern is the target of a binding, in a let statement or a function
+// parameter list: an *Identifier, *ArrayPattern or *HashPattern. The
+// patterns of match arms can also be, or contain, a *LiteralPattern.
+type Pattern interface {
+	Node
+	isPatternNode() // dummy for catching errors at compile time
+}
+
 type Program struct {
 	Statements []Statement
 }
@@ -33,6 +46,19 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -45,12 +71,16 @@ func (p *Program) String() string {
 
 type LetStatement struct {
 	Token token.Token
-	Name  *Identifier
+	Name  Pattern
 	Value Expression
 }
 
 func (ls *LetStatement) isStatementNode()     {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	return endOf(ls.Value, endOf(ls.Name, ls.Token.End))
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -72,7 +102,10 @@ type Identifier struct {
 }
 
 func (i *Identifier) isExpressionNode()    {}
+func (i *Identifier) isPatternNode()       {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 func (i *Identifier) String() string {
 	var out bytes.Buffer
 	out.WriteString(i.Value)
@@ -88,6 +121,10 @@ func (rs *ReturnStatement) isStatementNode() {}
 func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	return endOf(rs.ReturnValue, rs.Token.End)
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -108,6 +145,12 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) isStatementNode()     {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position {
+	return posOf(es.Expression, es.Token.Pos)
+}
+func (es *ExpressionStatement) End() token.Position {
+	return endOf(es.Expression, es.Token.End)
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -122,8 +165,21 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) isExpressionNode()    {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) isExpressionNode()    {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -131,7 +187,40 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) isExpressionNode()    {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+func (sl *StringLiteral) String() string       { return quoteString(sl.Value) }
+
+// TemplateLiteral is an interpolated string such as "a${x}b". Parts holds
+// the string's text as *StringLiteral and the interpolated expressions in
+// source order.
+type TemplateLiteral struct {
+	Token token.Token // the TEMPLATE_HEAD token
+	Parts []Expression
+	Tail  token.Token // the TEMPLATE_TAIL token
+}
+
+func (tl *TemplateLiteral) isExpressionNode()    {}
+func (tl *TemplateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TemplateLiteral) Pos() token.Position  { return tl.Token.Pos }
+func (tl *TemplateLiteral) End() token.Position  { return tl.Tail.End }
+func (tl *TemplateLiteral) String() string {
+	var out bytes.Buffer
+
+	out.WriteByte('"')
+	for _, part := range tl.Parts {
+		if sl, ok := part.(*StringLiteral); ok {
+			writeQuoted(&out, sl.Value)
+		} else {
+			out.WriteString("${")
+			out.WriteString(part.String())
+			out.WriteString("}")
+		}
+	}
+	out.WriteByte('"')
+
+	return out.String()
+}
 
 type PrefixExpression struct {
 	Token    token.Token // prefix token, e.g. !
@@ -141,6 +230,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) isExpressionNode()    {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return endOf(pe.Right, pe.Token.End) }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -161,6 +252,8 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) isExpressionNode()    {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return posOf(ie.Left, ie.Token.Pos) }
+func (ie *InfixExpression) End() token.Position  { return endOf(ie.Right, ie.Token.End) }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -173,6 +266,81 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// LogicalExpression is a short-circuiting && or || expression. Unlike
+// with an InfixExpression, Right is only evaluated if Left doesn't decide
+// the result on its own.
+type LogicalExpression struct {
+	Token    token.Token // the '&&' or '||' token
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (le *LogicalExpression) isExpressionNode()    {}
+func (le *LogicalExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LogicalExpression) Pos() token.Position  { return posOf(le.Left, le.Token.Pos) }
+func (le *LogicalExpression) End() token.Position  { return endOf(le.Right, le.Token.End) }
+func (le *LogicalExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(le.Left.String())
+	out.WriteString(le.Operator)
+	out.WriteString(le.Right.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// PipeExpression passes Left as the first argument to the call on the
+// right: xs |> map(f) calls map(xs, f). If Right isn't a call, it is
+// called with Left as its only argument: xs |> len calls len(xs).
+type PipeExpression struct {
+	Token token.Token // the '|>' token
+	Left  Expression
+	Right Expression
+}
+
+func (pe *PipeExpression) isExpressionNode()    {}
+func (pe *PipeExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PipeExpression) Pos() token.Position  { return posOf(pe.Left, pe.Token.Pos) }
+func (pe *PipeExpression) End() token.Position  { return endOf(pe.Right, pe.Token.End) }
+func (pe *PipeExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(pe.Left.String())
+	out.WriteString("|>")
+	out.WriteString(pe.Right.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// AssignExpression assigns Value to Target, which is an *Identifier or
+// an *IndexExpression.
+type AssignExpression struct {
+	Token  token.Token // the '=' token
+	Target Expression
+	Value  Expression
+}
+
+func (ae *AssignExpression) isExpressionNode()    {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return posOf(ae.Target, ae.Token.Pos) }
+func (ae *AssignExpression) End() token.Position  { return endOf(ae.Value, ae.Token.End) }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -180,6 +348,8 @@ type Boolean struct {
 
 func (b *Boolean) isExpressionNode()    {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type IfExpression struct {
@@ -191,6 +361,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) isExpressionNode()    {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return endOf(ie.Condition, ie.Token.End)
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -206,13 +386,68 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// MatchExpression evaluates to the body of the first arm whose pattern
+// matches the subject and whose guard, if any, is truthy, e.g.
+//
+//	match (shape) { {kind: "circle", r} => r * r, _ => 0 }
+type MatchExpression struct {
+	Token   token.Token // the 'match' token
+	Subject Expression
+	Arms    []MatchArm
+	Rbrace  token.Token // the '}' token
+}
+
+// MatchArm is a single pattern [if guard] => body case of a match.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // may be nil
+	Body    Expression // an expression or a *BlockStatement
+}
+
+func (ma MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if " + ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+func (me *MatchExpression) isExpressionNode()    {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) End() token.Position  { return me.Rbrace.End }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
 type BlockStatement struct {
 	Token      token.Token // the '{' Token
 	Statements []Statement
+	Rbrace     token.Token // the '}' token
 }
 
 func (bs *BlockStatement) isExpressionNode()    {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position  { return bs.Rbrace.End }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -223,23 +458,334 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) isStatementNode()     {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement is a for-in loop, which runs Body once for each element of
+// Iterable with Variable bound to the element.
+type ForStatement struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) isStatementNode()     {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (bs *BreakStatement) isStatementNode()     {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (cs *ContinueStatement) isStatementNode()     {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
+// ThrowStatement raises Value as an error, e.g. throw "not found".
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) isStatementNode()     {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position  { return endOf(ts.Value, ts.Token.End) }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// TryStatement runs Body and, if it fails, Catch with the error bound to
+// Param. Finally runs last in either case. At least one of Catch and
+// Finally is present.
+type TryStatement struct {
+	Token   token.Token // the 'try' token
+	Body    *BlockStatement
+	Param   *Identifier     // nil if there is no catch block
+	Catch   *BlockStatement // may be nil
+	Finally *BlockStatement // may be nil
+}
+
+func (ts *TryStatement) isStatementNode()     {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *TryStatement) End() token.Position {
+	switch {
+	case ts.Finally != nil:
+		return ts.Finally.End()
+	case ts.Catch != nil:
+		return ts.Catch.End()
+	}
+	return ts.Body.End()
+}
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(ts.Body.String())
+
+	if ts.Catch != nil {
+		out.WriteString(" catch(" + ts.Param.String() + ") ")
+		out.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(ts.Finally.String())
+	}
+
+	return out.String()
+}
+
+// ImportStatement binds a module, import "path" as name, or some of the
+// module's exports, import {a, b as c} from "path".
+type ImportStatement struct {
+	Token token.Token // the 'import' token
+	Path  *StringLiteral
+	Alias *Identifier  // the name of the module, nil if Names is used
+	Names []ImportSpec // the imported exports
+}
+
+// ImportSpec is a single name in an import list, e.g. b as c.
+type ImportSpec struct {
+	Name  *Identifier // the exported name
+	Alias *Identifier // the name to bind it to, nil if not renamed
+}
+
+// Local returns the name the import is bound to.
+func (is ImportSpec) Local() *Identifier {
+	if is.Alias != nil {
+		return is.Alias
+	}
+	return is.Name
+}
+
+func (is *ImportStatement) isStatementNode()     {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Pos() token.Position  { return is.Token.Pos }
+func (is *ImportStatement) End() token.Position {
+	if is.Alias != nil {
+		return is.Alias.End()
+	}
+	return endOf(is.Path, is.Token.End)
+}
+func (is *ImportStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(is.TokenLiteral() + " ")
+	if is.Alias != nil {
+		out.WriteString(is.Path.String())
+		out.WriteString(" as " + is.Alias.String())
+	} else {
+		names := []string{}
+		for _, spec := range is.Names {
+			name := spec.Name.String()
+			if spec.Alias != nil {
+				name += " as " + spec.Alias.String()
+			}
+			names = append(names, name)
+		}
+		out.WriteString("{" + strings.Join(names, ",") + "} from ")
+		out.WriteString(is.Path.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// ExportStatement is a let statement or function declaration at the top
+// level of a module whose names are visible to importers.
+type ExportStatement struct {
+	Token     token.Token // the 'export' token
+	Statement Statement   // *LetStatement or *FunctionStatement
+}
+
+func (es *ExportStatement) isStatementNode()     {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExportStatement) End() token.Position  { return endOf(es.Statement, es.Token.End) }
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+// ArrayPattern destructures an array, e.g. [a, b, ...tail]. Without Rest,
+// it only matches arrays of exactly len(Elements) elements.
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Pattern
+	Rest     *Identifier // bound to an array of the remaining elements, may be nil
+	Rbracket token.Token // the ']' token
+}
+
+func (ap *ArrayPattern) isPatternNode()       {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position  { return ap.Rbracket.End }
+func (ap *ArrayPattern) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ","))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+// HashPattern destructures a hash by string keys, e.g. {name, age: years}.
+type HashPattern struct {
+	Token  token.Token // the '{' token
+	Pairs  []HashPatternPair
+	Rbrace token.Token // the '}' token
+}
+
+// HashPatternPair binds the value of Key to Value. For a shorthand pair
+// like name in {name}, Value is an *Identifier with the key as its name.
+type HashPatternPair struct {
+	Key   *Identifier
+	Value Pattern
+}
+
+func (hp *HashPattern) isPatternNode()       {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position  { return hp.Rbrace.End }
+func (hp *HashPattern) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range hp.Pairs {
+		if ident, ok := pair.Value.(*Identifier); ok && ident.Value == pair.Key.Value {
+			pairs = append(pairs, pair.Key.String())
+		} else {
+			pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
+		}
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ","))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// DefaultPattern is a function parameter with a default value, e.g. the
+// y = 10 in fn(x, y = 10). Default is evaluated on each call that doesn't
+// pass the argument.
+type DefaultPattern struct {
+	Token   token.Token // the '=' token
+	Target  Pattern
+	Default Expression
+}
+
+func (dp *DefaultPattern) isPatternNode()       {}
+func (dp *DefaultPattern) TokenLiteral() string { return dp.Token.Literal }
+func (dp *DefaultPattern) Pos() token.Position  { return dp.Target.Pos() }
+func (dp *DefaultPattern) End() token.Position  { return endOf(dp.Default, dp.Token.End) }
+func (dp *DefaultPattern) String() string {
+	return dp.Target.String() + " = " + dp.Default.String()
+}
+
+// LiteralPattern matches values equal to a literal, e.g. 0, -1.5 or
+// "circle". It is only allowed in match arms.
+type LiteralPattern struct {
+	Value Expression // a literal, or a negated number literal
+}
+
+func (lp *LiteralPattern) isPatternNode()       {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) Pos() token.Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) End() token.Position  { return lp.Value.End() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
 type FunctionLiteral struct {
 	Token      token.Token // the 'fn' token
-	Parameters []*Identifier
+	Name       string      // from a declaration or let statement, may be empty
+	Parameters []Pattern
+	Rest       *Identifier // collects the remaining arguments, may be nil
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) isExpressionNode()    {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
 func (fl *FunctionLiteral) String() string {
+	return fl.format("")
+}
+
+// format returns the function's source with name between the 'fn' and the
+// parameter list.
+func (fl *FunctionLiteral) format(name string) string {
 	var out bytes.Buffer
 
 	params := []string{}
 	for _, p := range fl.Parameters {
 		params = append(params, p.String())
 	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
 
 	out.WriteString(fl.TokenLiteral())
+	if name != "" {
+		out.WriteString(" " + name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ","))
 	out.WriteString(")")
@@ -248,14 +794,69 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// FunctionStatement declares a named function, e.g. fn fact(n) { ... }.
+// The name is bound before the statements of the enclosing program or
+// block run, so the function can be called before its declaration.
+type FunctionStatement struct {
+	Token    token.Token // the 'fn' token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) isStatementNode()     {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *FunctionStatement) End() token.Position  { return fs.Function.End() }
+func (fs *FunctionStatement) String() string {
+	return fs.Function.format(fs.Name.String())
+}
+
+// MacroLiteral is a macro(params) { body } expression. Macros are bound by
+// let statements at the top level of a program and are called before the
+// program runs, with their arguments as unevaluated syntax.
+type MacroLiteral struct {
+	Token      token.Token // the 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) isExpressionNode()    {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return ml.Body.End()
+	}
+	return ml.Token.End
+}
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ","))
+	out.WriteString(")")
+	out.WriteString(ml.Body.String())
+
+	return out.String()
+}
+
 type CallExpression struct {
 	Token     token.Token // the '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	Rparen    token.Token // the ')' token
 }
 
 func (ce *CallExpression) isExpressionNode()    {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return posOf(ce.Function, ce.Token.Pos) }
+func (ce *CallExpression) End() token.Position  { return ce.Rparen.End }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -275,10 +876,13 @@ func (ce *CallExpression) String() string {
 type ArrayLiteral struct {
 	Token    token.Token //  the '[' Token
 	Elements []Expression
+	Rbracket token.Token // the ']' token
 }
 
 func (al *ArrayLiteral) isExpressionNode()    {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.Rbracket.End }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -295,13 +899,16 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token // the '[' token
-	Left  Expression
-	Index Expression
+	Token    token.Token // the '[' token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // the ']' token
 }
 
 func (ie *IndexExpression) isExpressionNode()    {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return posOf(ie.Left, ie.Token.Pos) }
+func (ie *IndexExpression) End() token.Position  { return ie.Rbracket.End }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -314,19 +921,75 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression takes the elements of an array or the characters of a
+// string from Low up to but not including High, e.g. xs[1:-1]. A missing
+// Low means the beginning, a missing High the end.
+type SliceExpression struct {
+	Token    token.Token // the '[' token
+	Left     Expression
+	Low      Expression  // may be nil
+	High     Expression  // may be nil
+	Rbracket token.Token // the ']' token
+}
+
+func (se *SliceExpression) isExpressionNode()    {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position  { return posOf(se.Left, se.Token.Pos) }
+func (se *SliceExpression) End() token.Position  { return se.Rbracket.End }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// MemberExpression accesses a member by name, e.g. strings.upper.
+type MemberExpression struct {
+	Token    token.Token // the '.' token
+	Object   Expression
+	Property *Identifier
+}
+
+func (me *MemberExpression) isExpressionNode()    {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Pos() token.Position  { return posOf(me.Object, me.Token.Pos) }
+func (me *MemberExpression) End() token.Position  { return endOf(me.Property, me.Token.End) }
+func (me *MemberExpression) String() string {
+	return "(" + me.Object.String() + "." + me.Property.String() + ")"
+}
+
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token  token.Token // the '{' token
+	Pairs  []HashPair  // in source order
+	Rbrace token.Token // the '}' token
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) isExpressionNode()    {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position  { return hl.Rbrace.End }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -335,3 +998,63 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// posOf returns the start position of n, or fallback if n is missing
+// (as can happen after a parse error).
+func posOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	return n.Pos()
+}
+
+// endOf returns the end position of n, or fallback if n is missing
+// (as can happen after a parse error).
+func endOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	return n.End()
+}
+
+// quoteString returns s as a double-quoted string literal that the lexer
+// reads back as s.
+func quoteString(s string) string {
+	var out bytes.Buffer
+
+	out.WriteByte('"')
+	writeQuoted(&out, s)
+	out.WriteByte('"')
+
+	return out.String()
+}
+
+// writeQuoted writes s to out escaped for use between the quotes of a
+// string literal.
+func writeQuoted(out *bytes.Buffer, s string) {
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(out, "\\x%02x", s[0])
+		case r == '"' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '$' && strings.HasPrefix(s[size:], "{"):
+			out.WriteString("\\$")
+		case r == '\n':
+			out.WriteString("\\n")
+		case r == '\t':
+			out.WriteString("\\t")
+		case r == '\r':
+			out.WriteString("\\r")
+		case r < utf8.RuneSelf && !unicode.IsPrint(r):
+			fmt.Fprintf(out, "\\x%02x", r)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(out, "\\u{%x}", r)
+		default:
+			out.WriteRune(r)
+		}
+		s = s[size:]
+	}
+}
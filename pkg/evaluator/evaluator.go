@@ -2,18 +2,48 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/sbrki/monkey/pkg/ast"
 	"github.com/sbrki/monkey/pkg/object"
+	"github.com/sbrki/monkey/pkg/token"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
+)
+
+// Kinds of errors. Errors raised by the evaluator are RuntimeErrors, while
+// thrown errors have the kind given to throw, or ThrownError.
+const (
+	RuntimeError = "RuntimeError"
+	ThrownError  = "Error"
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+
+	// every expression has a value, e.g. a block without one is null
+	if result == nil {
+		if _, ok := node.(ast.Expression); ok {
+			return NULL
+		}
+	}
+
+	// errors are raised at the innermost node they come out of
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -21,36 +51,64 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		right := Eval(node.Right, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
+	case *ast.LogicalExpression:
+		return evalLogicalExpression(node, env)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if err := bindPattern(node.Name, val, env); err != nil {
+			return err
+		}
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.ImportStatement:
+		if err := evalImportStatement(node, env); err != nil {
+			return err
+		}
+	case *ast.ExportStatement:
+		return Eval(node.Statement, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return newThrownError(val)
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.CallExpression:
 		// Function field in CallExpression can be either
 		// FunctionLiteral or Identifier. The call to Eval
@@ -58,51 +116,74 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		// It also takes care of building the object.Function ad-hoc in case
 		// it is a FunctionLiteral. In either case, it returns the
 		// object.Function object that is ready for execution.
+		if isSpecialFormCall(node, "quote") {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments to quote. got=%d, want=1", len(node.Arguments))
+			}
+			return quote(node.Arguments[0], env)
+		}
+
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+
+		return callFunction(function, args, node.Pos())
+	case *ast.PipeExpression:
+		return evalPipeExpression(node, env)
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.TemplateLiteral:
+		return evalTemplateLiteral(node, env)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{
-			Parameters: params,
-			Body:       body,
-			Env:        env, // closure
-		}
+		return newFunction(node, env)
+	case *ast.FunctionStatement:
+		// already bound by hoistFunctions
+	case *ast.MacroLiteral:
+		// top-level macro definitions are removed by DefineMacros
+		return newError("macro definitions are only allowed in top-level let statements")
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+	case *ast.MemberExpression:
+		obj := Eval(node.Object, env)
+		if isAbrupt(obj) {
+			return obj
+		}
+		return evalMemberExpression(obj, node.Property.Value)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	}
 
 	return nil
@@ -111,6 +192,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
+	hoistFunctions(program.Statements, env)
+
 	for _, statement := range program.Statements {
 		result = Eval(statement, env)
 
@@ -128,12 +211,15 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
+	hoistFunctions(block.Statements, env)
+
 	for _, statement := range block.Statements {
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ,
+				object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -142,6 +228,137 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// evalTryStatement runs the try block, then the catch block if the try
+// block failed, and then the finally block. A finally block that fails or
+// leaves a function or loop takes precedence over the other blocks.
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(ts.Body, env)
+
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		catchEnv.Set(ts.Param.Value, errorHash(err))
+		result = Eval(ts.Catch, catchEnv)
+	}
+
+	if ts.Finally != nil {
+		finally := Eval(ts.Finally, env)
+		if finally != nil {
+			switch finally.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ,
+				object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return finally
+			}
+		}
+	}
+
+	return result
+}
+
+// errorHash returns the value a catch block binds err to: a hash with the
+// error's "message", "kind", "position" and thrown "value".
+func errorHash(err *object.Error) *object.Hash {
+	value := err.Value
+	if value == nil {
+		value = NULL
+	}
+
+	hash := object.NewHash()
+	hash.Set(&object.String{Value: "message"}, &object.String{Value: err.Message})
+	hash.Set(&object.String{Value: "kind"}, &object.String{Value: err.Kind})
+	hash.Set(&object.String{Value: "position"}, &object.String{Value: err.Pos.String()})
+	hash.Set(&object.String{Value: "value"}, value)
+	return hash
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := Eval(ws.Body, env)
+		if done, result := loopControl(result); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+
+	var elements []object.Object
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		elements = iterable.Elements
+	case *object.Hash:
+		for _, key := range iterable.Keys {
+			elements = append(elements, iterable.Pairs[key].Key)
+		}
+	case *object.String:
+		for _, char := range iterable.Value {
+			elements = append(elements, &object.String{Value: string(char)})
+		}
+	default:
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	for _, element := range elements {
+		// each iteration gets its own variable, so that closures
+		// created in the body see the element of their iteration
+		loopEnv := object.NewEnclosedEnvironment(env)
+		loopEnv.Set(fs.Variable.Value, element)
+
+		result := Eval(fs.Body, loopEnv)
+		if done, result := loopControl(result); done {
+			return result
+		}
+	}
+	return NULL
+}
+
+// loopControl handles the result of one run of a loop body. It reports
+// whether the loop is done and if so, what it evaluates to.
+func loopControl(result object.Object) (bool, object.Object) {
+	switch result := result.(type) {
+	case *object.Break:
+		return true, NULL
+	case *object.ReturnValue, *object.Error:
+		return true, result
+	}
+	// a Continue needs no handling, as it already ended the body
+	return false, nil
+}
+
+// hoistFunctions binds the functions declared in statements, so that
+// they can be called before their declaration and from each other.
+func hoistFunctions(statements []ast.Statement, env *object.Environment) {
+	for _, statement := range statements {
+		if exportStmt, ok := statement.(*ast.ExportStatement); ok {
+			statement = exportStmt.Statement
+		}
+		if fnStmt, ok := statement.(*ast.FunctionStatement); ok {
+			env.Set(fnStmt.Name.Value, newFunction(fnStmt.Function, env))
+		}
+	}
+}
+
+func newFunction(fl *ast.FunctionLiteral, env *object.Environment) *object.Function {
+	return &object.Function{
+		Name:       fl.Name,
+		Parameters: fl.Parameters,
+		Rest:       fl.Rest,
+		Body:       fl.Body,
+		Env:        env, // closure
+	}
+}
+
 func nativeBoolToBooleanObject(in bool) *object.Boolean {
 	if in {
 		return TRUE
@@ -155,6 +372,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangPrefixOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalTildePrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -177,12 +396,23 @@ func evalBangPrefixOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
+}
+
+func evalTildePrefixOperatorExpression(right object.Object) object.Object {
+	if right.Type() != object.INTEGER_OBJ {
+		return newError("unknown operator: ~%s", right.Type())
+	}
 
 	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return &object.Integer{Value: ^value}
 }
 
 func evalInfixExpression(
@@ -192,10 +422,16 @@ func evalInfixExpression(
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// at least one of the operands is a float, the other one is
+		// promoted to float as well
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case operator == ">>" && isCallable(left) && isCallable(right):
+		return compose(left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -218,12 +454,47 @@ func evalIntegerInfixExpression(
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 {
+			// the result is a fraction, e.g. 2 ** -1 == 0.5
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		return &object.Integer{Value: intPow(leftVal, rightVal)}
+
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		return &object.Integer{Value: leftVal << rightVal}
+	case ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
 
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -234,6 +505,75 @@ func evalIntegerInfixExpression(
 	}
 }
 
+// intPow returns base ** exp for exp >= 0, wrapping around on overflow
+// like the other integer operators.
+func intPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat converts an Integer or a Float to float64.
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func evalBooleanInfixExpression(
 	operator string,
 	left, right object.Object,
@@ -264,9 +604,59 @@ func evalStringInfixExpression(
 	}
 }
 
+// evalTemplateLiteral concatenates the parts of an interpolated string.
+// Interpolated strings are inserted as is, other values as they are
+// displayed by Inspect.
+func evalTemplateLiteral(
+	tl *ast.TemplateLiteral,
+	env *object.Environment,
+) object.Object {
+	var out strings.Builder
+	for _, part := range tl.Parts {
+		val := Eval(part, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if str, ok := val.(*object.String); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString(val.Inspect())
+		}
+	}
+	return &object.String{Value: out.String()}
+}
+
+// evalLogicalExpression evaluates && and || with short-circuiting. The
+// result is the operand that decided it, not necessarily a Boolean:
+// 0 || "x" evaluates to 0, null || "x" to "x".
+func evalLogicalExpression(
+	le *ast.LogicalExpression,
+	env *object.Environment,
+) object.Object {
+	left := Eval(le.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	switch le.Operator {
+	case "&&":
+		if !isTruthy(left) {
+			return left
+		}
+	case "||":
+		if isTruthy(left) {
+			return left
+		}
+	default:
+		return newError("unknown operator: %s", le.Operator)
+	}
+
+	return Eval(le.Right, env)
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
@@ -278,6 +668,40 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isAbrupt(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		// the names bound by the pattern are only visible in the arm
+		armEnv := object.NewEnclosedEnvironment(env)
+
+		matched, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isAbrupt(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return newError("no match arm matches %s", subject.Inspect())
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -311,7 +735,7 @@ func evalExpressions(
 
 	for _, expr := range exprs {
 		evaluated := Eval(expr, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -319,28 +743,293 @@ func evalExpressions(
 	return result
 }
 
+// callFunction applies fn to args in a call at pos, adding the call to
+// the stack of the error it fails with.
+func callFunction(fn object.Object, args []object.Object, pos token.Position) object.Object {
+	result := applyFunction(fn, args)
+	if err, ok := result.(*object.Error); ok {
+		if fn, ok := fn.(*object.Function); ok {
+			err.Stack = append(err.Stack, object.Frame{Function: fn.Name, Call: pos})
+		}
+	}
+	return result
+}
+
+// evalPipeExpression calls the right side of pe with the left side as the
+// first argument.
+func evalPipeExpression(pe *ast.PipeExpression, env *object.Environment) object.Object {
+	left := Eval(pe.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	call, ok := pe.Right.(*ast.CallExpression)
+	if !ok {
+		function := Eval(pe.Right, env)
+		if isAbrupt(function) {
+			return function
+		}
+		return callFunction(function, []object.Object{left}, pe.Right.Pos())
+	}
+
+	function := Eval(call.Function, env)
+	if isAbrupt(function) {
+		return function
+	}
+
+	args := evalExpressions(call.Arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+
+	return callFunction(function, append([]object.Object{left}, args...), call.Pos())
+}
+
+func isCallable(obj object.Object) bool {
+	return obj.Type() == object.FUNCTION_OBJ || obj.Type() == object.BUILTIN_OBJ
+}
+
+// compose returns the composition f >> g.
+func compose(f, g object.Object) *object.Composition {
+	functions := []object.Object{}
+	for _, fn := range []object.Object{f, g} {
+		if composition, ok := fn.(*object.Composition); ok {
+			functions = append(functions, composition.Functions...)
+		} else {
+			functions = append(functions, fn)
+		}
+	}
+	return &object.Composition{Functions: functions}
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv, err := extendFunctionEnv(fn, args)
+		if err != nil {
+			return err
+		}
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
 		return fn.Fn(args...)
+	case *object.Composition:
+		result := applyFunction(fn.Functions[0], args)
+		for _, next := range fn.Functions[1:] {
+			if isError(result) {
+				return result
+			}
+			result = applyFunction(next, []object.Object{result})
+		}
+		return result
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// extendFunctionEnv returns a new environment for a call of fn, with the
+// parameters bound to args. Parameters without an argument take their
+// default values, which are evaluated in the new environment so that they
+// can refer to the parameters before them.
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
-) *object.Environment {
+) (*object.Environment, *object.Error) {
+	if err := checkArity(fn, len(args)); err != nil {
+		return nil, err
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		target := param
+		if def, ok := param.(*ast.DefaultPattern); ok {
+			target = def.Target
+		}
+
+		var arg object.Object
+		if paramIdx < len(args) {
+			arg = args[paramIdx]
+		} else {
+			// checkArity made sure this parameter has a default
+			arg = Eval(param.(*ast.DefaultPattern).Default, env)
+			if err, ok := arg.(*object.Error); ok {
+				return nil, err
+			}
+		}
+
+		if err := bindPattern(target, arg, env); err != nil {
+			return nil, err
+		}
+	}
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		env.Set(fn.Rest.Value, &object.Array{Elements: rest})
+	}
+
+	return env, nil
+}
+
+// checkArity returns an error if fn can't be called with n arguments.
+func checkArity(fn *object.Function, n int) *object.Error {
+	max := len(fn.Parameters)
+	min := 0
+	for _, param := range fn.Parameters {
+		if _, ok := param.(*ast.DefaultPattern); !ok {
+			min += 1
+		}
+	}
+
+	switch {
+	case fn.Rest != nil && n < min:
+		return newError("wrong number of arguments. got=%d, want at least %d", n, min)
+	case fn.Rest == nil && min == max && n != min:
+		return newError("wrong number of arguments. got=%d, want=%d", n, min)
+	case fn.Rest == nil && (n < min || n > max):
+		return newError("wrong number of arguments. got=%d, want=%d to %d", n, min, max)
+	}
+	return nil
+}
+
+// bindPattern binds the names in pattern to the matching parts of val in
+// env. It returns an error if val doesn't have the shape of pattern.
+func bindPattern(pattern ast.Pattern, val object.Object, env *object.Environment) *object.Error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		env.Set(pattern.Value, val)
+		return nil
+
+	case *ast.ArrayPattern:
+		array, ok := val.(*object.Array)
+		if !ok {
+			return newError("cannot destructure %s as ARRAY", val.Type())
+		}
+		want := len(pattern.Elements)
+		got := len(array.Elements)
+		if pattern.Rest == nil && got != want {
+			return newError("cannot destructure array of length %d into %d names", got, want)
+		}
+		if got < want {
+			return newError("cannot destructure array of length %d into at least %d names", got, want)
+		}
+
+		for i, element := range pattern.Elements {
+			if err := bindPattern(element, array.Elements[i], env); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := make([]object.Object, got-want)
+			copy(rest, array.Elements[want:])
+			env.Set(pattern.Rest.Value, &object.Array{Elements: rest})
+		}
+		return nil
+
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return newError("cannot destructure %s as HASH", val.Type())
+		}
+
+		for _, pair := range pattern.Pairs {
+			key := &object.String{Value: pair.Key.Value}
+			value, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				return newError("cannot destructure hash without key %q", pair.Key.Value)
+			}
+			if err := bindPattern(pair.Value, value.Value, env); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return newError("unknown pattern: %T", pattern)
+	}
+}
+
+// matchPattern reports whether val matches pattern, binding the names in
+// the pattern to the matching parts of val in env. The wildcard _ matches
+// anything without binding it.
+func matchPattern(pattern ast.Pattern, val object.Object, env *object.Environment) (bool, *object.Error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			env.Set(pattern.Value, val)
+		}
+		return true, nil
+
+	case *ast.LiteralPattern:
+		literal := Eval(pattern.Value, env)
+		if err, ok := literal.(*object.Error); ok {
+			return false, err
+		}
+		return objectsEqual(literal, val), nil
+
+	case *ast.ArrayPattern:
+		array, ok := val.(*object.Array)
+		if !ok {
+			return false, nil
+		}
+
+		n := len(pattern.Elements)
+		if len(array.Elements) < n || pattern.Rest == nil && len(array.Elements) != n {
+			return false, nil
+		}
+		for i, element := range pattern.Elements {
+			matched, err := matchPattern(element, array.Elements[i], env)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := make([]object.Object, len(array.Elements)-n)
+			copy(rest, array.Elements[n:])
+			env.Set(pattern.Rest.Value, &object.Array{Elements: rest})
+		}
+		return true, nil
+
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return false, nil
+		}
+
+		for _, pair := range pattern.Pairs {
+			key := &object.String{Value: pair.Key.Value}
+			value, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				return false, nil
+			}
+			matched, err := matchPattern(pair.Value, value.Value, env)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		return true, nil
+
+	default:
+		return false, newError("unknown pattern: %T", pattern)
 	}
-	return env
+}
+
+// objectsEqual reports whether a and b are equal numbers, strings or
+// booleans.
+func objectsEqual(a, b object.Object) bool {
+	switch {
+	case a.Type() == object.INTEGER_OBJ && b.Type() == object.INTEGER_OBJ:
+		return a.(*object.Integer).Value == b.(*object.Integer).Value
+	case isNumber(a) && isNumber(b):
+		return toFloat(a) == toFloat(b)
+	case a.Type() == object.STRING_OBJ && b.Type() == object.STRING_OBJ:
+		return a.(*object.String).Value == b.(*object.String).Value
+	case a.Type() == object.BOOLEAN_OBJ && b.Type() == object.BOOLEAN_OBJ:
+		return a.(*object.Boolean).Value == b.(*object.Boolean).Value
+	}
+	return false
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -351,6 +1040,11 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
 	}
+	// a body without a value, e.g. one ending in a let statement,
+	// returns null
+	if obj == nil {
+		return NULL
+	}
 	return obj
 }
 
@@ -358,6 +1052,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -366,6 +1062,19 @@ func evalIndexExpression(left, index object.Object) object.Object {
 
 }
 
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	switch obj := obj.(type) {
+	case *object.Module:
+		val, ok := obj.Exports[name]
+		if !ok {
+			return newError("module %q does not export %s", obj.Path, name)
+		}
+		return val
+	default:
+		return newError("member access not supported: %s", obj.Type())
+	}
+}
+
 func evalArrayIndexExpression(left, index object.Object) object.Object {
 	arrayObject := left.(*object.Array)
 
@@ -379,6 +1088,107 @@ func evalArrayIndexExpression(left, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// evalStringIndexExpression returns the character at index as a string.
+// Strings are indexed by character, not by byte.
+func evalStringIndexExpression(left, index object.Object) object.Object {
+	chars := []rune(left.(*object.String).Value)
+
+	idx := index.(*object.Integer).Value
+	max := int64(len(chars) - 1)
+
+	if idx < 0 || idx > max {
+		return NULL
+	}
+
+	return &object.String{Value: string(chars[idx])}
+}
+
+func evalSliceExpression(se *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(se.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	var low, high object.Object
+	if se.Low != nil {
+		low = Eval(se.Low, env)
+		if isAbrupt(low) {
+			return low
+		}
+	}
+	if se.High != nil {
+		high = Eval(se.High, env)
+		if isAbrupt(high) {
+			return high
+		}
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		start, end, err := sliceBounds(low, high, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		elements := make([]object.Object, end-start)
+		copy(elements, left.Elements[start:end])
+		return &object.Array{Elements: elements}
+
+	case *object.String:
+		chars := []rune(left.Value)
+		start, end, err := sliceBounds(low, high, len(chars))
+		if err != nil {
+			return err
+		}
+		return &object.String{Value: string(chars[start:end])}
+
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+// sliceBounds returns the start and end of the slice [low:high] of a
+// sequence of length n. A nil low or high stands for the start or end of
+// the sequence. Negative bounds count from the end, and bounds outside
+// the sequence are clamped to it.
+func sliceBounds(low, high object.Object, n int) (int, int, *object.Error) {
+	start, err := sliceBound(low, 0, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := sliceBound(high, n, n)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if end < start {
+		end = start
+	}
+	return start, end, nil
+}
+
+func sliceBound(bound object.Object, missing, n int) (int, *object.Error) {
+	if bound == nil {
+		return missing, nil
+	}
+
+	integer, ok := bound.(*object.Integer)
+	if !ok {
+		return 0, newError("slice index must be INTEGER, got %s", bound.Type())
+	}
+
+	idx := integer.Value
+	if idx < 0 {
+		idx += int64(n)
+	}
+	switch {
+	case idx < 0:
+		return 0, nil
+	case idx > int64(n):
+		return n, nil
+	}
+	return int(idx), nil
+}
+
 func evalHashIndexExpression(left, index object.Object) object.Object {
 	hashObject := left.(*object.Hash)
 
@@ -395,15 +1205,78 @@ func evalHashIndexExpression(left, index object.Object) object.Object {
 	return pair.Value
 }
 
+// evalAssignExpression evaluates an assignment to a variable or an
+// element of an array or hash, returning the assigned value.
+//
+// Arrays and hashes are never copied, not by let, assignment or passing
+// them to a function, so an element assigned through one name is visible
+// through every other name for the same array or hash. Only builtins
+// like push and rest return new arrays.
+func evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := ae.Target.(type) {
+	case *ast.Identifier:
+		val := Eval(ae.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if !env.Assign(target.Value, val) {
+			return newError("assignment to undeclared identifier: %s", target.Value)
+		}
+		return val
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		val := Eval(ae.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val)
+
+	default:
+		return newError("cannot assign to %s", ae.Target)
+	}
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", idx.Value)
+		}
+		left.Elements[idx.Value] = val
+		return val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, val)
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isAbrupt(key) {
 			return key
 		}
 
@@ -412,29 +1285,73 @@ func evalHashLiteral(
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(pair.Value, env)
+		if isAbrupt(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{
-			Key:   key,
-			Value: value,
-		}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{
 		Message: fmt.Sprintf(format, a...),
+		Kind:    RuntimeError,
 	}
 }
 
+// newThrownError returns the error raised by throwing val. Throwing a
+// hash with "message" or "kind" strings, such as an error bound by a catch
+// block, uses them as the error's message and kind.
+func newThrownError(val object.Object) *object.Error {
+	err := &object.Error{
+		Message: val.Inspect(),
+		Kind:    ThrownError,
+		Value:   val,
+	}
+
+	if hash, ok := val.(*object.Hash); ok {
+		if message, ok := hashString(hash, "message"); ok {
+			err.Message = message
+		}
+		if kind, ok := hashString(hash, "kind"); ok {
+			err.Kind = kind
+		}
+	}
+	return err
+}
+
+func hashString(hash *object.Hash, key string) (string, bool) {
+	pair, ok := hash.Pairs[(&object.String{Value: key}).HashKey()]
+	if !ok {
+		return "", false
+	}
+	str, ok := pair.Value.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return str.Value, true
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
 	}
 	return false
 }
+
+// isAbrupt reports whether obj ends the evaluation of the expression it
+// comes out of: an error, or the result of a return, break or continue
+// statement in a block used as a value, such as the branch of an if.
+func isAbrupt(obj object.Object) bool {
+	if obj != nil {
+		switch obj.Type() {
+		case object.ERROR_OBJ, object.RETURN_VALUE_OBJ,
+			object.BREAK_OBJ, object.CONTINUE_OBJ:
+			return true
+		}
+	}
+	return false
+}
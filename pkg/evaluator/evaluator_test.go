@@ -1,8 +1,12 @@
 package evaluator
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/sbrki/monkey/pkg/ast"
 	"github.com/sbrki/monkey/pkg/lexer"
 	"github.com/sbrki/monkey/pkg/object"
 	"github.com/sbrki/monkey/pkg/parser"
@@ -28,10 +32,24 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"0xff + 0b1 + 0o7 + 1_000", 1263},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"5 ** 0", 1},
+		{"0b1100 & 0b1010", 8},
+		{"0b1100 | 0b1010", 14},
+		{"0b1100 ^ 0b1010", 6},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 | 2 << 1 + 1", 9},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -49,10 +67,89 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.25", -2.25},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 * 0.1", 1},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999},
+		{"2 * (0.25 + 0.25)", 1},
+		{"7.5 % 2", 1.5},
+		{"2 ** -1", 0.5},
+		{"4 ** 0.5", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("Could not downcast object.Object to object.Float, got = %T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("Float.Value = %g, expected = %g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestFloatInspectRoundTrip(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3.0", "3.0"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"1e21", "1e+21"},
+		{"1.5e-7", "1.5e-07"},
+		{"-100.0", "-100.0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		inspected := evaluated.Inspect()
+		if inspected != tt.expected {
+			t.Errorf("Inspect() = %s, expected = %s", inspected, tt.expected)
+			continue
+		}
+
+		reparsed := testEval(t, inspected)
+		testFloatObject(t, reparsed, evaluated.(*object.Float).Value)
+	}
+
+	// infinities and NaN have no literal form, so they don't read back
+	noLiteral := []struct {
+		input    string
+		expected string
+	}{
+		{"1.0 / 0", "+Inf"},
+		{"-1.0 / 0", "-Inf"},
+		{"(0 - 2) ** 0.5", "NaN"},
+	}
+
+	for _, tt := range noLiteral {
+		if inspected := testEval(t, tt.input).Inspect(); inspected != tt.expected {
+			t.Errorf("Inspect() = %s, expected = %s", inspected, tt.expected)
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello, world!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("Could not downcast object.Object to object.String, got=%T (%+v)", evaluated, evaluated)
@@ -66,7 +163,7 @@ func TestStringLiteral(t *testing.T) {
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("Could not downcast object.Object to object.String, got=%T (%+v)", evaluated, evaluated)
@@ -77,6 +174,31 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestTemplateLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; "hello ${name}!"`, "hello Ann!"},
+		{`let items = [1, 2, 3]; "you have ${len(items)} items"`, "you have 3 items"},
+		{`"${1 + 1.5} ${true} ${[1, "a"]}"`, "2.5 true [1,a]"},
+		{`let f = fn(x) { "<${x}>" }; "${f(f("a"))}"`, "<<a>>"},
+		{`"\${not interpolated}"`, "${not interpolated}"},
+		{"`raw\n${x}\\n`", "raw\n${x}\\n"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. expected=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -101,10 +223,22 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"1 <= 1", true},
+		{"1 <= 0", false},
+		{"1 >= 1", true},
+		{"0 >= 1", false},
+		{"1.5 <= 1", false},
+		{"2 >= 1.5", true},
+		{"1 & 1 == 1", true},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -122,6 +256,19 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	return true
 }
 
+func testStringObject(t *testing.T, obj object.Object, expected string) bool {
+	result, ok := obj.(*object.String)
+	if !ok {
+		t.Errorf("object is not String. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("String has wrong value. expected=%q, got=%q", expected, result.Value)
+		return false
+	}
+	return true
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -136,11 +283,48 @@ func TestBangOperator(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"5 && 10", 10},
+		{"0 || 10", 0},
+		{"false || 10", 10},
+		{"if (false) { 1 } || 10", 10},
+		{"if (false) { 1 } && 10", nil},
+		// the right operand is not evaluated, so the unknown
+		// identifier doesn't produce an error
+		{"false && undefined", false},
+		{"true || undefined", true},
+		{"let f = fn() { 1 / 0 }; false && f()", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -157,7 +341,7 @@ func TestIfElseExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -175,6 +359,59 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	return true
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (false) { 10 }", nil},
+		{"while (true) { break; 10 }", nil},
+		{"while (true) { if (true) { return 10; } }", 10},
+		{"for (x in [1, 2, 3]) { x }", nil},
+		{"for (x in []) { return 10 }", nil},
+		{"for (x in [1, 2, 3]) { if (x == 2) { return x } }", 2},
+		{"for (x in [1, 2, 3]) { if (x < 3) { continue; } return x * 10; }", 30},
+		{"for (x in [1, 2, 3]) { if (x == 2) { break; } return x; }", 1},
+		{"for (x in [1, 2, 3]) { if (x < 10) { continue; } return x; }", nil},
+		{`for (k in {"b": 1, "a": 2}) { return k }`, "b"},
+		{`let h = {"b": 1, "a": 2}; for (k in h) { if (h[k] == 2) { return k } }`, "a"},
+		{`let s = ""; for (c in "héllo") { s = c + s }; s`, "olléh"},
+		// break and continue only affect the innermost loop
+		{"for (x in [1, 2]) { for (y in [1, 2]) { break; } return x; }", 1},
+		{"for (x in [1, 2]) { for (y in [1, 2]) { continue; } return x; }", 1},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 3) { return x } } }; f()", 3},
+		{"let f = fn() { while (true) { return 5 } }; f() + 1", 6},
+		// the loop variable is local to the loop
+		{"let x = 7; for (x in [1, 2]) { }; x", 7},
+		// break, continue and return in a block used as a value leave
+		// the expression
+		{"let i = 0; while (i < 3) { i = i + 1; let v = if (true) { break; }; } i", 1},
+		{"let i = 0; let n = 0; while (i < 3) { i = i + 1; n = if (i > 1) { continue; } else { n + 1 }; } n", 1},
+		{"let a = []; for (x in [1, 2]) { a = push(a, if (x == 2) { break; } else { x }) }; len(a)", 1},
+		{`for (x in [1, 2]) { let s = "${if (true) { break; }}"; return 5 }`, nil},
+		{"let f = fn() { let v = [if (true) { return 4; }]; 5 }; f()", 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. expected=%q, got=%q", expected, str.Value)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -198,7 +435,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -212,6 +449,38 @@ func TestErrorHandling(t *testing.T) {
 			"5 + true;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"true && undefined",
+			"identifier not found: undefined",
+		},
+		{
+			"1 % 0",
+			"division by zero",
+		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
+		{
+			"~1.5",
+			"unknown operator: ~FLOAT",
+		},
+		{
+			"1.5 + true;",
+			"type mismatch: FLOAT + BOOLEAN",
+		},
+		{
+			"{1.5: 1}",
+			"unusable as hash key: FLOAT",
+		},
 		{
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
@@ -251,6 +520,86 @@ func TestErrorHandling(t *testing.T) {
 			`"Hello" - "World!"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"let [a, b] = [1];",
+			"cannot destructure array of length 1 into 2 names",
+		},
+		{
+			"let [a] = [1, 2];",
+			"cannot destructure array of length 2 into 1 names",
+		},
+		{
+			"let [a, b, ...c] = [1];",
+			"cannot destructure array of length 1 into at least 2 names",
+		},
+		{
+			"let [a] = 5;",
+			"cannot destructure INTEGER as ARRAY",
+		},
+		{
+			`let {a} = [1];`,
+			"cannot destructure ARRAY as HASH",
+		},
+		{
+			`let {name} = {"age": 1};`,
+			`cannot destructure hash without key "name"`,
+		},
+		{
+			`let {pos: [x, y]} = {"pos": [1]};`,
+			"cannot destructure array of length 1 into 2 names",
+		},
+		{
+			"let f = fn([a]) { a }; f(1)",
+			"cannot destructure INTEGER as ARRAY",
+		},
+		{
+			"x = 5",
+			"assignment to undeclared identifier: x",
+		},
+		{
+			"let f = fn() { let y = 1 }; f(); y = 2",
+			"assignment to undeclared identifier: y",
+		},
+		{
+			"let a = [1]; a[1] = 2",
+			"index out of range: 1",
+		},
+		{
+			"let a = [1]; a[-1] = 2",
+			"index out of range: -1",
+		},
+		{
+			`let a = [1]; a["0"] = 2`,
+			"array index must be INTEGER, got STRING",
+		},
+		{
+			`let h = {}; h[fn(x) { x }] = 1`,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`let s = "abc"; s[0] = "x"`,
+			"index assignment not supported: STRING",
+		},
+		{
+			"let a = [1]; a[0] = foobar",
+			"identifier not found: foobar",
+		},
+		{
+			"for (x in 5) { x }",
+			"not iterable: INTEGER",
+		},
+		{
+			"while (foobar) { 1 }",
+			"identifier not found: foobar",
+		},
+		{
+			"for (x in [1, 2]) { x + true }",
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			`"a${foobar}b"`,
+			"identifier not found: foobar",
+		},
 		{
 			`{"name": "Monkey"}[fn(x) {x}]`,
 			"unusable as hash key: FUNCTION",
@@ -258,7 +607,7 @@ func TestErrorHandling(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		errorObj, ok := evaluated.(*object.Error)
 		if !ok {
@@ -283,13 +632,95 @@ func TestLetStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a = 10; a", 10},
+		{"let a = 5; a = a * 2", 10},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		// assignment updates the binding in the scope that has it
+		{"let a = 1; let f = fn() { a = 2 }; f(); a", 2},
+		{"let a = 1; let f = fn() { let a = 5; a = 2 }; f(); a", 1},
+		{"let a = 1; let f = fn(a) { a = 2 }; f(1); a", 1},
+		{`
+		let counter = fn() {
+			let n = 0;
+			fn() { n = n + 1 }
+		};
+		let c = counter();
+		c(); c();
+		c()
+		`, 3},
+		{"let i = 0; let sum = 0; while (i < 5) { i = i + 1; sum = sum + i; }; sum", 15},
+		{"let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } sum = sum + x; }; sum", 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a", "[1,5,3]"},
+		{"let a = [1, 2, 3]; a[0] = a[1] = 7", "7"},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] = 3; h`, "{a: 3,b: 2}"},
+		{`let h = {}; h[1] = "one"; h[true] = "yes"; h`, "{1: one,true: yes}"},
+		{"let a = [[1], [2]]; a[1][0] = 3; a", "[[1],[3]]"},
+		// arrays and hashes are shared, not copied
+		{"let a = [1]; let b = a; b[0] = 2; a", "[2]"},
+		{"let a = [1]; let f = fn(x) { x[0] = 2 }; f(a); a", "[2]"},
+		{`let h = {"k": [1]}; let inner = h["k"]; inner[0] = 9; h`, "{k: [9]}"},
+		{"let a = [1]; let b = push(a, 2); b[0] = 5; a", "[1]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = [1, 2]; a + b", "3"},
+		{"let [a, b, ...tail] = [1, 2, 3, 4]; tail", "[3,4]"},
+		{"let [a, ...tail] = [1]; tail", "[]"},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c", "6"},
+		{`let {name, age: years} = {"name": "Ann", "age": 42}; "${name} ${years}"`, "Ann 42"},
+		{`let {pos: [x, y]} = {"pos": [3, 4], "extra": true}; x * y`, "12"},
+		{`let [{id}, {id: other}] = [{"id": 1}, {"id": 2}]; [id, other]`, "[1,2]"},
+		// the rest array is a copy
+		{"let xs = [1, 2, 3]; let [a, ...tail] = xs; tail[0] = 9; xs", "[1,2,3]"},
+		{"let f = fn([a, b], {c}) { a + b + c }; f([1, 2], {\"c\": 3})", "6"},
+		{"let swap = fn([a, b]) { [b, a] }; swap([1, 2])", "[2,1]"},
+		{"let f = fn(x) { let [y] = x; y }; f([5])", "5"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
 	}
 }
 
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) {x + 2;};"
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 
 	if !ok {
@@ -325,7 +756,7 @@ func TestFunctionApplication(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -337,6 +768,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("größe")`, 5},
 		{`len(1)`, "argument to `len` not supported, got=INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 		{`len([])`, 0},
@@ -356,7 +788,7 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		switch expected := tt.expected.(type) {
 		case int:
@@ -376,87 +808,367 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
-func TestClosures(t *testing.T) {
-	input := `
-	let newAdder = fn(x) {
-		fn(y) {x + y};
-	}
-	let addTwo = newAdder(2);
-	addTwo(2);
-	`
-	testIntegerObject(t, testEval(input), 4)
-}
-
-func TestArrayLiterals(t *testing.T) {
-	input := "[1, 2 * 2, 3 + 3]"
-
-	evaluated := testEval(input)
-	result, ok := evaluated.(*object.Array)
-	if !ok {
-		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", "11"},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", "3"},
+		{"let f = fn(x = 1, y = x * 2) { [x, y] }; f()", "[1,2]"},
+		{"let f = fn(x = 1, y = x * 2) { [x, y] }; f(5)", "[5,10]"},
+		{"let f = fn(first, ...others) { others }; f(1, 2, 3)", "[2,3]"},
+		{"let f = fn(first, ...others) { others }; f(1)", "[]"},
+		{"let f = fn(...args) { len(args) }; f()", "0"},
+		{"let f = fn(a, b = 2, ...c) { [a, b, c] }; f(1, 5, 6, 7)", "[1,5,[6,7]]"},
+		{"let f = fn([a, b] = [1, 2]) { a + b }; f()", "3"},
+		// defaults are evaluated on each call
+		{"let n = 1; let f = fn(x = n) { x }; n = 2; f()", "2"},
+		{"let f = fn(x = [0]) { x[0] = x[0] + 1; x }; f(); f()", "[1]"},
+		// defaults see the function's closure, not the caller's scope
+		{"let y = 1; let f = fn(x = y) { x }; let g = fn(y) { f() }; g(5)", "1"},
 	}
 
-	if len(result.Elements) != 3 {
-		t.Fatalf("len(result.Elements) != 3, got=%d", len(result.Elements))
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("input %q: expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
 	}
-
-	testIntegerObject(t, result.Elements[0], 1)
-	testIntegerObject(t, result.Elements[1], 4)
-	testIntegerObject(t, result.Elements[2], 6)
 }
 
-func TestArrayIndexExpressions(t *testing.T) {
+func TestArityErrors(t *testing.T) {
 	tests := []struct {
 		input    string
-		expected interface{}
+		expected string
 	}{
-		{
-			"[1,2,3][0]",
-			1,
-		},
-		{
-			"[1,2,3][1]",
-			2,
-		},
-		{
-			"[1,2,3][2]",
-			3,
-		},
-		{
-			"let myArray = [1,2,3]; myArray[2];",
-			3,
-		},
-		{
-			"let myArray = [1,2,3]; myArray[0] + myArray[1] + myArray[2]",
-			6,
-		},
-		{
-			"let myArray = [1,2,3]; let i = myArray[0]; myArray[i];",
-			2,
-		},
-		{
-			"[1,2,3][3]",
-			nil,
-		},
-		{
-			"[1,2,3][-1]",
-			nil,
-		},
+		{"fn(x) { x }()", "wrong number of arguments. got=0, want=1"},
+		{"fn(x) { x }(1, 2)", "wrong number of arguments. got=2, want=1"},
+		{"fn() { 1 }(1)", "wrong number of arguments. got=1, want=0"},
+		{"fn(x, y = 1) { x }()", "wrong number of arguments. got=0, want=1 to 2"},
+		{"fn(x, y = 1) { x }(1, 2, 3)", "wrong number of arguments. got=3, want=1 to 2"},
+		{"fn(x, y, ...z) { x }(1)", "wrong number of arguments. got=1, want at least 2"},
+		{"fn(x = foobar) { x }()", "identifier not found: foobar"},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		integer, ok := tt.expected.(int)
-		if ok {
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			testNullObject(t, evaluated)
+		evaluated := testEval(t, tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("input %q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("input %q: wrong error message. expected=%q, got=%q",
+				tt.input, tt.expected, errObj.Message)
 		}
 	}
 }
 
-func TestHashLiterals(t *testing.T) {
-	input := `let two = "two";
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5)", 120},
+		// declarations are hoisted
+		{"let x = double(4); fn double(n) { n * 2 }; x", 8},
+		{`
+		fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+		fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+		if (isEven(10) && isOdd(7)) { 1 } else { 0 }
+		`, 1},
+		// declarations in a function body are local to it
+		{`
+		fn outer() {
+			let r = inner();
+			fn inner() { 42 }
+			r
+		}
+		outer()
+		`, 42},
+		{"let inner = 1; fn outer() { fn inner() { 2 } inner() }; outer() + inner", 3},
+		// a declaration closes over the scope it is declared in
+		{"fn make(n) { fn get() { n } get }; make(7)()", 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestExpressionsWithoutValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"fn(){}()", nil},
+		{"let f = fn() { fn g() {} }; f()", nil},
+		{"if (true) {}", nil},
+		{`"${fn(){}()}"`, "null"},
+		{`try { throw fn(){}() } catch (e) { e["message"] }`, "null"},
+		{"match (fn(){}()) { _ => 1 }", 1},
+		{"for (x in fn(){}()) {}", "not iterable: NULL"},
+		{"fn(){}()[1:]", "slice operator not supported: NULL"},
+		{"(fn(x) {} >> len)(1)", "argument to `len` not supported, got=NULL"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			var got string
+			switch obj := evaluated.(type) {
+			case *object.String:
+				got = obj.Value
+			case *object.Error:
+				got = obj.Message
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if got != expected {
+				t.Errorf("wrong result. expected=%q, got=%q", expected, got)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestFunctionNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(x, y) { x + y }; add", "fn add(x,y) {\n(x+y)\n}"},
+		{"let square = fn(x) { x * x }; square", "fn square(x) {\n(x*x)\n}"},
+		{"fn(x) { x }", "fn(x) {\nx\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		fn, ok := evaluated.(*object.Function)
+		if !ok {
+			t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
+		}
+		if fn.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. expected=%q, got=%q", tt.expected, fn.Inspect())
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `fn divide(a, b) {
+	if (b == 0) { return 1 + true; }
+	a / b
+}
+let half = fn(x) { divide(x, 0) };
+let apply = fn(f, x) { f(x) };
+apply(half, 10)`
+
+	evaluated := testEval(t, input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := []string{
+		"in divide, called at 5:20",
+		"in half, called at 6:24",
+		"in apply, called at 7:1",
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. expected=%d, got=%d (%v)", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, frame := range errObj.Stack {
+		if frame.String() != expected[i] {
+			t.Errorf("stack[%d] wrong. expected=%q, got=%q", i, expected[i], frame.String())
+		}
+	}
+
+	expectedInspect := "ERROR: 2:23: type mismatch: INTEGER + BOOLEAN\n" +
+		"\tin divide, called at 5:20\n" +
+		"\tin half, called at 6:24\n" +
+		"\tin apply, called at 7:1"
+	if errObj.Inspect() != expectedInspect {
+		t.Errorf("wrong Inspect.\nexpected=%q\ngot=%q", expectedInspect, errObj.Inspect())
+	}
+
+	evaluated = testEval(t, "fn(x) { x + true }(1)")
+	errObj, ok = evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if len(errObj.Stack) != 1 || errObj.Stack[0].String() != "in anonymous function, called at 1:1" {
+		t.Errorf("wrong stack for anonymous function. got=%v", errObj.Stack)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	input := `
+	let newAdder = fn(x) {
+		fn(y) {x + y};
+	}
+	let addTwo = newAdder(2);
+	addTwo(2);
+	`
+	testIntegerObject(t, testEval(t, input), 4)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(t, input)
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("len(result.Elements) != 3, got=%d", len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{
+			"[1,2,3][0]",
+			1,
+		},
+		{
+			"[1,2,3][1]",
+			2,
+		},
+		{
+			"[1,2,3][2]",
+			3,
+		},
+		{
+			"let myArray = [1,2,3]; myArray[2];",
+			3,
+		},
+		{
+			"let myArray = [1,2,3]; myArray[0] + myArray[1] + myArray[2]",
+			6,
+		},
+		{
+			"let myArray = [1,2,3]; let i = myArray[0]; myArray[i];",
+			2,
+		},
+		{
+			"[1,2,3][3]",
+			nil,
+		},
+		{
+			"[1,2,3][-1]",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"abc"[0]`, "a"},
+		{`"abc"[2]`, "c"},
+		{`let s = "abc"; s[len(s) - 1]`, "c"},
+		{`"größe"[2]`, "ö"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, nil},
+		{`""[0]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		testStringObject(t, evaluated, expected)
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`[1, 2, 3, 4][1:3]`, []int64{2, 3}},
+		{`[1, 2, 3, 4][:2]`, []int64{1, 2}},
+		{`[1, 2, 3, 4][2:]`, []int64{3, 4}},
+		{`[1, 2, 3, 4][:]`, []int64{1, 2, 3, 4}},
+		{`[1, 2, 3, 4][-3:-1]`, []int64{2, 3}},
+		{`[1, 2, 3, 4][-10:10]`, []int64{1, 2, 3, 4}},
+		{`[1, 2, 3, 4][3:1]`, []int64{}},
+		{`[1, 2, 3, 4][4:]`, []int64{}},
+		{`[][:]`, []int64{}},
+		// slices are copies
+		{`let a = [1, 2, 3]; let b = a[:2]; b[0] = 9; a`, []int64{1, 2, 3}},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:-1]`, "hell"},
+		{`"hello"[-3:]`, "llo"},
+		{`"hello"[3:1]`, ""},
+		{`"größe"[1:4]`, "röß"},
+		{`"abc"[:"b"]`, "slice index must be INTEGER, got STRING"},
+		{`5[1:2]`, "slice operator not supported: INTEGER"},
+		{`[1][x:]`, "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case []int64:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong number of elements. want=%d, got=%d",
+					len(expected), len(array.Elements))
+				continue
+			}
+			for i, want := range expected {
+				testIntegerObject(t, array.Elements[i], want)
+			}
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				if err.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
 	{
 		"one": 10 - 9,
 		two: 1 + 1,
@@ -466,7 +1178,7 @@ func TestHashLiterals(t *testing.T) {
 		false: 6
 	}`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
@@ -495,6 +1207,44 @@ func TestHashLiterals(t *testing.T) {
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"z": 1, "a": 2, 10: 3, true: 4, "m": 5}`, "{z: 1,a: 2,10: 3,true: 4,m: 5}"},
+		// a repeated key keeps its first place and takes the last value
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3,b: 2}"},
+		{`{}`, `{}`},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			evaluated := testEval(t, tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Fatalf("hash inspected out of order. expected=%s, got=%s",
+					tt.expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestHashLiteralEvaluationOrder(t *testing.T) {
+	// the first failing key or value in source order is reported
+	input := `{"a": 1, foo: 2, "b": bar, baz: 3}`
+
+	for i := 0; i < 10; i++ {
+		evaluated := testEval(t, input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+		}
+		if errObj.Message != "identifier not found: foo" {
+			t.Fatalf("wrong error message. got=%q", errObj.Message)
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -509,7 +1259,7 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -519,11 +1269,654 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
-func testEval(input string) object.Object {
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Fatalf("input %q has parser errors: %v", input, errors)
+	}
 	env := object.NewEnvironment()
 
 	return Eval(program, env)
 }
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5+8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar+barfoo)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8+8)`},
+		{`quote(unquote(4 + 4) + 8)`, `(8+8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(2.5))`, `2.5`},
+		{`quote(unquote(3.0))`, `3.0`},
+		{`quote(unquote("monkey"))`, `"monkey"`},
+		{`quote(unquote(true))`, `true`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4+4)`},
+		{`let quotedInfix = quote(4 + 4); quote(unquote(4 + 4) + unquote(quotedInfix))`, `(8+(4+4))`},
+		// values without a literal form are left unquoted
+		{`quote(unquote([1, 2]))`, `unquote([1,2])`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func testQuoteObject(t *testing.T, obj object.Object, expected string) bool {
+	quote, ok := obj.(*object.Quote)
+	if !ok {
+		t.Errorf("expected *object.Quote. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if quote.Node == nil {
+		t.Errorf("quote.Node is nil")
+		return false
+	}
+
+	if quote.Node.String() != expected {
+		t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), expected)
+		return false
+	}
+	return true
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+	if macro.Parameters[0].String() != "x" || macro.Parameters[1].String() != "y" {
+		t.Fatalf("parameters wrong. got=%s, %s", macro.Parameters[0], macro.Parameters[1])
+	}
+
+	if macro.Body.String() != "(x+y)" {
+		t.Fatalf("body is not %q. got=%q", "(x+y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`
+			let infixExpression = macro() { quote(1 + 2); };
+
+			infixExpression();
+			`,
+			`(1 + 2)`,
+		},
+		{
+			`
+			let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+
+			reverse(2 + 2, 10 - 5);
+			`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("unexpected expansion error: %s", err.Message)
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestMacroExpansionErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{
+			`let m = macro(a) { quote(unquote(a)) }; m(1, 2);`,
+			"wrong number of arguments to macro m. got=2, want=1",
+		},
+		{
+			`let m = macro(a) { 5 }; m(1);`,
+			"macro m must return quoted code, got INTEGER",
+		},
+		{
+			`let m = macro() { -true }; m();`,
+			"unknown operator: -BOOLEAN",
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil {
+			t.Errorf("no expansion error for %q", tt.input)
+			continue
+		}
+
+		if err.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, err.Message)
+		}
+	}
+}
+
+func TestMacroPrograms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{
+			`
+			let unless = macro(cond, cons, alt) {
+				quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) })
+			};
+			unless(1 > 2, 10, 20);
+			`,
+			10,
+		},
+		{
+			`
+			let assert_eq = macro(actual, expected) {
+				quote(if (unquote(actual) != unquote(expected)) {
+					"assertion failed: " + unquote(str(actual)) + " != " + unquote(str(expected))
+				} else {
+					true
+				})
+			};
+			assert_eq(1 + 1, 3);
+			`,
+			"assertion failed: (1+1) != 3",
+		},
+		{`let m = fn() { macro(x) { x } }; m();`, "macro definitions are only allowed in top-level let statements"},
+		{`str(quote(1 + foo))`, "(1+foo)"},
+		{`str([1, 2])`, "[1,2]"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		macroEnv := object.NewEnvironment()
+		DefineMacros(program, macroEnv)
+		expanded, err := ExpandMacros(program, macroEnv)
+		if err != nil {
+			t.Fatalf("unexpected expansion error: %s", err.Message)
+		}
+
+		evaluated := Eval(expanded, object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			var got string
+			switch obj := evaluated.(type) {
+			case *object.String:
+				got = obj.Value
+			case *object.Error:
+				got = obj.Message
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if got != expected {
+				t.Errorf("wrong result. expected=%q, got=%q", expected, got)
+			}
+		}
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/strings.mk": `
+			let prefix = ">";
+			export let greeting = "hello";
+			export fn shout(s) { prefix + s + "!" }
+			export let [one, two] = [1, 2];
+		`,
+		"lib/nested.mk": `
+			import "./strings.mk" as s;
+			export let both = s.shout(s.greeting);
+		`,
+		"shared/items.mk": `export let items = [1];`,
+		"search/util.mk":  `export fn double(x) { x * 2 }`,
+		"hoisted.mk": `
+			export let result = later();
+			export fn later() { 7 }
+		`,
+	})
+	SearchPath = []string{filepath.Join(dir, "search")}
+	defer func() { SearchPath = nil }()
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`import "lib/strings.mk" as s; s.shout(s.greeting)`, ">hello!"},
+		{`import "lib/strings.mk" as s; s.one + s.two`, 3},
+		{`import {shout, greeting as g} from "lib/strings.mk"; shout(g)`, ">hello!"},
+		{`import "lib/nested.mk" as n; n.both`, ">hello!"},
+		{`import "util.mk" as u; u.double(21)`, 42},
+		{`import "hoisted.mk" as h; h.result`, 7},
+		// a module is evaluated once, however often it is imported
+		{`import "shared/items.mk" as a; import "shared/items.mk" as b; a.items[0] = 5; b.items[0]`, 5},
+		{`import "lib/strings.mk" as s; s.prefix`, fmt.Sprintf("module %q does not export prefix", filepath.Join(dir, "lib", "strings.mk"))},
+		{`import {prefix} from "lib/strings.mk"`, `module "lib/strings.mk" does not export prefix`},
+		{`import "missing.mk" as m`, `cannot find module "missing.mk"`},
+		{`import "./util.mk" as u`, `cannot find module "./util.mk"`},
+		{`let x = 5; x.y`, "member access not supported: INTEGER"},
+		{`let h = {"a": 1}; h.a`, "member access not supported: HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalFile(t, filepath.Join(dir, "main.mk"), tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				if err.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+				}
+				continue
+			}
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. expected=%q, got=%q", expected, str.Value)
+			}
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.mk":      `import "b.mk" as b; export let x = 1;`,
+		"b.mk":      `import "a.mk" as a; export let y = 2;`,
+		"c.mk":      `import "main.mk" as m; export let z = 3;`,
+		"main.mk":   ``,
+		"syntax.mk": `let = 5;`,
+		"fails.mk":  `export let z = 1 + true;`,
+	})
+
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{
+			`import "a.mk" as a`,
+			"import cycle: " + filepath.Join(dir, "a.mk") + " -> " + filepath.Join(dir, "b.mk") + " -> " + filepath.Join(dir, "a.mk"),
+		},
+		{
+			`import "c.mk" as c`,
+			"import cycle: " + filepath.Join(dir, "main.mk") + " -> " + filepath.Join(dir, "c.mk") + " -> " + filepath.Join(dir, "main.mk"),
+		},
+		{
+			`import "syntax.mk" as s`,
+			filepath.Join(dir, "syntax.mk") + ":1:5: expected a name or a destructuring pattern, got = '='",
+		},
+		{
+			`import "fails.mk" as f`,
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalFile(t, filepath.Join(dir, "main.mk"), tt.input)
+
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if err.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, err.Message)
+		}
+	}
+}
+
+// writeModules writes files, a map from slash-separated paths to source,
+// to a temporary directory and returns the directory.
+func writeModules(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func testEvalFile(t *testing.T, filename, input string) object.Object {
+	t.Helper()
+	l := lexer.NewFile(filename, input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Fatalf("input %q has parser errors: %v", input, errors)
+	}
+	env := object.NewEnvironment()
+
+	return EvalFile(filename, program, env)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (0) { 0 => 1, _ => 2 }`, 1},
+		{`match (5) { 0 => 1, _ => 2 }`, 2},
+		{`match (5) { n => n * 2 }`, 10},
+		{`match (-1) { -1 => 1, _ => 2 }`, 1},
+		{`match (2.0) { 2 => 1, _ => 2 }`, 1},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (1 < 2) { false => 1, true => 2 }`, 2},
+		{`match ("1") { 1 => 1, _ => 2 }`, 2},
+		{`match ([]) { [] => 0, [x, ...rest] => x }`, 0},
+		{`match ([4, 5, 6]) { [] => 0, [x, ...rest] => x + len(rest) }`, 6},
+		{`match ([1, 2]) { [a] => a, [a, b] => a + b }`, 3},
+		{`match ([1, [2, 3]]) { [a, [b, 3]] => a + b }`, 3},
+		{`match ([1, 2]) { [2, b] => b, [1, b] => b * 10 }`, 20},
+		{`match ({"type": "circle", "r": 3}) { {type: "square", side} => side, {type: "circle", r} => r * r }`, 9},
+		{`match ({"a": 1}) { {b} => b, {a} => a }`, 1},
+		{`match (5) { [x] => x, {x} => x, _ => 0 }`, 0},
+		{`match (-3) { x if x > 0 => x, x if x < 0 => -x }`, 3},
+		{`match ([3]) { [x] if x > 5 => 1, [x] => 2 }`, 2},
+		// a block arm and a return from inside it
+		{`let f = fn(x) { match (x) { 0 => { return 10; }, _ => { let y = x; y + 1 } }; 99 }; f(0) + f(1)`, 109},
+		// names bound by one arm don't leak into the next or the enclosing scope
+		{`let x = 7; match ([1]) { [x] if x > 5 => x, _ => x }`, 7},
+		{`let x = 7; match (1) { x => x }; x`, 7},
+		{`let classify = fn(n) { match (n) { 0 => "zero", n if n < 0 => "negative", _ => "positive" } }; len(classify(-4))`, 8},
+		{`match (4) { 1 => 1, 2 => 2 }`, "no match arm matches 4"},
+		{`match ([1, 2]) { [x] => x }`, "no match arm matches [1,2]"},
+		{`match (1) { x if x + true => 1 }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`match (y) { _ => 1 }`, "identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if err.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+			}
+		}
+	}
+}
+
+func TestPipeExpressions(t *testing.T) {
+	prelude := `
+	let map = fn(xs, f) { let out = []; for (x in xs) { out = push(out, f(x)) }; out };
+	let filter = fn(xs, f) { let out = []; for (x in xs) { if (f(x)) { out = push(out, x) } }; out };
+	let reduce = fn(xs, f, acc) { for (x in xs) { acc = f(acc, x) }; acc };
+	`
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`[1, 2, 3] |> len`, 3},
+		{`[1, 2, 3] |> len()`, 3},
+		{`[1, 2, 3] |> map(fn(x) { x * 2 }) |> reduce(fn(a, b) { a + b }, 0)`, 12},
+		{`[1, 2, 3, 4] |> filter(fn(x) { x % 2 == 0 }) |> map(fn(x) { x * x }) |> last`, 16},
+		{`5 |> fn(x) { x + 1 }`, 6},
+		{`let add = fn(a, b) { a + b }; 1 |> add(2) |> add(3)`, 6},
+		{`let x = 0; x = 4 |> fn(n) { n * n }; x`, 16},
+		{`1 |> 2`, "not a function: INTEGER"},
+		{`1 |> nope()`, "identifier not found: nope"},
+		{`let add = fn(a, b) { a + b }; 1 |> add()`, "wrong number of arguments. got=1, want=2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, prelude+tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if err.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+			}
+		}
+	}
+}
+
+func TestFunctionComposition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc >> double)(3)`, 8},
+		{`let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (double >> inc)(3)`, 7},
+		{`let inc = fn(x) { x + 1 }; let f = inc >> inc >> inc; f(0)`, 3},
+		{`let add = fn(a, b) { a + b }; (add >> fn(x) { x * 10 })(1, 2)`, 30},
+		{`(rest >> len)([1, 2, 3])`, 2},
+		{`let inc = fn(x) { x + 1 }; [1, 2] |> (len >> inc)`, 3},
+		// >> is still a shift on integers
+		{`16 >> 2`, 4},
+		{`let f = fn(x) { x }; f >> 1`, "type mismatch: FUNCTION >> INTEGER"},
+		{`let f = fn(x) { x + true }; (len >> f)([])`, "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if err.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+			}
+		}
+	}
+
+	composition, ok := testEval(t, `let f = fn(x) { x }; f >> len`).(*object.Composition)
+	if !ok {
+		t.Fatalf("object is not Composition.")
+	}
+	if composition.Type() != object.FUNCTION_OBJ {
+		t.Errorf("composition has wrong type. got=%s", composition.Type())
+	}
+	if len(composition.Functions) != 2 {
+		t.Errorf("composition has wrong number of functions. got=%d", len(composition.Functions))
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { throw "oops" } catch (e) { e["message"] }`, "oops"},
+		{`try { throw "oops" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw 42 } catch (e) { e["value"] }`, 42},
+		{`try { throw {"kind": "ValueError", "message": "bad"} } catch (e) { e["kind"] + ": " + e["message"] }`, "ValueError: bad"},
+		{`try { throw {"kind": "ValueError", "code": 7} } catch (e) { e["value"]["code"] }`, 7},
+		// runtime errors and errors from builtins are caught the same way
+		{`try { 1 + true } catch (e) { e["message"] }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got=INTEGER"},
+		{`try { nope } catch (e) { e["position"] }`, "1:7"},
+		{`try {
+			throw "x"
+		} catch (e) { e["position"] }`, "2:4"},
+		{`let f = fn() { 1 + true }; try { f() } catch (e) { e["position"] }`, "1:16"},
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw 1; 5 } catch (e) { 2 }`, 2},
+		// errors unwind through function calls
+		{`let f = fn(x) { if (x > 2) { throw "too big" }; x }; try { f(1) + f(5) } catch (e) { e["message"] }`, "too big"},
+		{`let f = fn() { try { throw 1 } catch (e) { return 10 }; 20 }; f()`, 10},
+		// finally always runs, and its result is discarded
+		{`let log = []; try { log = push(log, 1) } finally { log = push(log, 2) }; len(log)`, 2},
+		{`let log = []; try { throw 1 } catch (e) { log = push(log, 1) } finally { log = push(log, 2) }; len(log)`, 2},
+		{`let x = 0; let f = fn() { try { return 1 } finally { x = 5 } }; f() + x`, 6},
+		{`try { 1 } finally { 2 }`, 1},
+		// a finally block that fails or returns takes over
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`try { try { throw "a" } finally { throw "b" } } catch (e) { e["message"] }`, "b"},
+		// without a catch block the error continues after finally
+		{`let x = 0; try { try { throw "a" } finally { x = 1 } } catch (e) { e["message"] + x }`, "type mismatch: STRING + INTEGER"},
+		{`let x = 0; try { try { throw "a" } finally { x = 1 } } catch (e) { x }`, 1},
+		// rethrowing keeps the message and kind
+		{`try { try { throw {"kind": "K", "message": "m"} } catch (e) { throw e } } catch (e) { e["kind"] + e["message"] }`, "Km"},
+		{`try { try { 1 + true } catch (e) { throw e } } catch (e) { e["kind"] }`, "RuntimeError"},
+		// errors in catch blocks aren't caught by the same statement
+		{`try { throw "a" } catch (e) { throw "b" }`, "b"},
+		// the catch parameter is local to the catch block
+		{`let e = 1; try { throw 2 } catch (e) { e }; e`, 1},
+		{`for (x in [1, 2, 3]) { try { if (x == 2) { break } } finally { } }; 7`, 7},
+		{`throw "uncaught"`, "uncaught"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				if err.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestThrownErrorFields(t *testing.T) {
+	evaluated := testEval(t, `let f = fn() { throw {"kind": "NotFound", "message": "no such key"} }; f()`)
+
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if err.Kind != "NotFound" || err.Message != "no such key" {
+		t.Errorf("wrong kind or message. got=%q, %q", err.Kind, err.Message)
+	}
+	if err.Pos.String() != "1:16" {
+		t.Errorf("wrong position. got=%s", err.Pos)
+	}
+	if _, ok := err.Value.(*object.Hash); !ok {
+		t.Errorf("thrown value is not Hash. got=%T", err.Value)
+	}
+	if len(err.Stack) != 1 || err.Stack[0].String() != "in f, called at 1:72" {
+		t.Errorf("wrong stack. got=%v", err.Stack)
+	}
+}
@@ -0,0 +1,188 @@
+package evaluator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbrki/monkey/pkg/ast"
+	"github.com/sbrki/monkey/pkg/lexer"
+	"github.com/sbrki/monkey/pkg/object"
+	"github.com/sbrki/monkey/pkg/parser"
+	"github.com/sbrki/monkey/pkg/token"
+)
+
+// SearchPath holds the directories in which imported files are looked
+// up when they aren't found next to the importing file. Paths starting
+// with "./" or "../" are only looked up next to the importing file.
+var SearchPath []string
+
+var (
+	// modules holds the modules imported so far by path, so that each
+	// file is only evaluated once.
+	modules = map[string]*object.Module{}
+	// importing holds the paths of the modules being evaluated, in
+	// the order they were imported.
+	importing []string
+)
+
+// EvalFile evaluates node, the program in the file at path, as the entry
+// module, so that importing the file while it runs reports an import
+// cycle instead of evaluating it a second time.
+func EvalFile(path string, node ast.Node, env *object.Environment) object.Object {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	importing = append(importing, path)
+	defer func() { importing = importing[:len(importing)-1] }()
+
+	return Eval(node, env)
+}
+
+func evalImportStatement(is *ast.ImportStatement, env *object.Environment) *object.Error {
+	path, err := resolveImport(is.Path.Value, is.Token.Pos)
+	if err != nil {
+		return err
+	}
+
+	module, err := importModule(path)
+	if err != nil {
+		return err
+	}
+
+	if is.Alias != nil {
+		env.Set(is.Alias.Value, module)
+		return nil
+	}
+
+	for _, spec := range is.Names {
+		val, ok := module.Exports[spec.Name.Value]
+		if !ok {
+			return newError("module %q does not export %s", is.Path.Value, spec.Name.Value)
+		}
+		env.Set(spec.Local().Value, val)
+	}
+	return nil
+}
+
+// resolveImport returns the absolute path of the file imported as path by
+// the file containing pos.
+func resolveImport(path string, pos token.Position) (string, *object.Error) {
+	var candidates []string
+
+	switch {
+	case filepath.IsAbs(path):
+		candidates = []string{path}
+	case strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../"):
+		candidates = []string{filepath.Join(filepath.Dir(pos.Filename), path)}
+	default:
+		candidates = []string{filepath.Join(filepath.Dir(pos.Filename), path)}
+		for _, dir := range SearchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			abs, err := filepath.Abs(candidate)
+			if err != nil {
+				return "", newError("cannot import %q: %s", path, err)
+			}
+			return abs, nil
+		}
+	}
+	return "", newError("cannot find module %q", path)
+}
+
+// importModule returns the module in the file at path, evaluating the
+// file if it hasn't been imported before.
+func importModule(path string) (*object.Module, *object.Error) {
+	if module, ok := modules[path]; ok {
+		return module, nil
+	}
+
+	for i, p := range importing {
+		if p == path {
+			cycle := append(append([]string{}, importing[i:]...), path)
+			return nil, newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, newError("cannot import %q: %s", path, readErr)
+	}
+
+	importing = append(importing, path)
+	defer func() { importing = importing[:len(importing)-1] }()
+
+	p := parser.New(lexer.NewFile(path, string(src)))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		errors.RemoveDuplicates()
+		return nil, newError("%s", errors)
+	}
+
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	expanded, err := ExpandMacros(program, macroEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	env := object.NewEnvironment()
+	if result := Eval(expanded, env); isError(result) {
+		return nil, result.(*object.Error)
+	}
+
+	module := &object.Module{Path: path, Exports: map[string]object.Object{}}
+	for _, statement := range program.Statements {
+		exportStmt, ok := statement.(*ast.ExportStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range boundNames(exportStmt.Statement) {
+			if val, ok := env.Get(name); ok {
+				module.Exports[name] = val
+			}
+		}
+	}
+
+	modules[path] = module
+	return module, nil
+}
+
+// boundNames returns the names bound by a let statement or function
+// declaration.
+func boundNames(stmt ast.Statement) []string {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return patternNames(stmt.Name, nil)
+	case *ast.FunctionStatement:
+		return []string{stmt.Name.Value}
+	}
+	return nil
+}
+
+// patternNames appends the names bound by pattern to names.
+func patternNames(pattern ast.Pattern, names []string) []string {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		names = append(names, pattern.Value)
+	case *ast.DefaultPattern:
+		names = patternNames(pattern.Target, names)
+	case *ast.ArrayPattern:
+		for _, element := range pattern.Elements {
+			names = patternNames(element, names)
+		}
+		if pattern.Rest != nil {
+			names = append(names, pattern.Rest.Value)
+		}
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			names = patternNames(pair.Value, names)
+		}
+	}
+	return names
+}
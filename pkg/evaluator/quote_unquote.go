@@ -0,0 +1,81 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/sbrki/monkey/pkg/ast"
+	"github.com/sbrki/monkey/pkg/object"
+	"github.com/sbrki/monkey/pkg/token"
+)
+
+// quote returns node unevaluated, except for the unquote(...) calls in it,
+// which are replaced by their evaluated arguments.
+func quote(node ast.Node, env *object.Environment) object.Object {
+	node = evalUnquoteCalls(node, env)
+	return &object.Quote{Node: node}
+}
+
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
+	return ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if !isUnquoteCall(node) {
+			return node
+		}
+
+		call, ok := node.(*ast.CallExpression)
+		if !ok || len(call.Arguments) != 1 {
+			return node
+		}
+
+		unquoted := Eval(call.Arguments[0], env)
+		if converted := convertObjectToASTNode(unquoted, call); converted != nil {
+			return converted
+		}
+		return node
+	})
+}
+
+func isUnquoteCall(node ast.Node) bool {
+	return isSpecialFormCall(node, "unquote")
+}
+
+// isSpecialFormCall reports whether node is a call of the special form
+// name, like quote(...).
+func isSpecialFormCall(node ast.Node, name string) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// convertObjectToASTNode returns a literal that evaluates to obj, placed
+// at the position of the unquote call it replaces. It returns nil for
+// objects that have no literal form.
+func convertObjectToASTNode(obj object.Object, call *ast.CallExpression) ast.Node {
+	pos, end := call.Pos(), call.End()
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value), Pos: pos, End: end}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+	case *object.Float:
+		t := token.Token{Type: token.FLOAT, Literal: obj.Inspect(), Pos: pos, End: end}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Pos: pos, End: end}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Pos: pos, End: end}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false", Pos: pos, End: end}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}
+	case *object.Quote:
+		return obj.Node
+	default:
+		return nil
+	}
+}
@@ -1,84 +1,562 @@
 package lexer
 
-import "github.com/sbrki/monkey/pkg/token"
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/sbrki/monkey/pkg/token"
+)
+
+// DiagnosticKind classifies the problems the lexer reports.
+type DiagnosticKind int
+
+const (
+	IllegalCharacter DiagnosticKind = iota
+	InvalidEncoding
+	InvalidEscape
+	InvalidNumber
+	UnterminatedString
+	UnterminatedComment
+	ReadError
+)
+
+var diagnosticKindNames = [...]string{
+	IllegalCharacter:    "illegal character",
+	InvalidEncoding:     "invalid encoding",
+	InvalidEscape:       "invalid escape",
+	InvalidNumber:       "invalid number",
+	UnterminatedString:  "unterminated string",
+	UnterminatedComment: "unterminated comment",
+	ReadError:           "read error",
+}
+
+func (k DiagnosticKind) String() string {
+	if int(k) < len(diagnosticKindNames) {
+		return diagnosticKindNames[k]
+	}
+	return fmt.Sprintf("DiagnosticKind(%d)", int(k))
+}
+
+// Diagnostic describes a problem the lexer found in its input.
+type Diagnostic struct {
+	Kind DiagnosticKind
+	Pos  token.Position
+	Msg  string
+}
+
+func (d Diagnostic) String() string {
+	return d.Pos.String() + ": " + d.Msg
+}
+
+// Lexer turns UTF-8 encoded source text into tokens. It works on runes
+// rather than bytes, so token columns count runes, while offsets count
+// bytes.
+//
+// The input is read incrementally, so that tokens are available before
+// all of it has been read; the lexer only looks a few bytes past the
+// current character, e.g. to recognize "...".
 type Lexer struct {
-	input    string
+	filename string
+	reader   *bufio.Reader
 	currPos  int
-	currChar byte
+	currChar rune
 	nextPos  int
+
+	// raw bytes of currChar, which differ from its UTF-8 encoding if
+	// the input isn't valid UTF-8
+	currRaw  [utf8.UTFMax]byte
+	currSize int
+
+	// line and column of currChar
+	line   int
+	column int
+
+	// lexeme collects the raw bytes of the characters read between
+	// calls to startLexeme and lexemeString
+	lexeme   []byte
+	inLexeme bool
+
+	// templates holds the interpolations ${...} the lexer is in,
+	// innermost last
+	templates []template
+
+	diagnostics []Diagnostic
+	readFailed  bool
+}
+
+// template tracks an interpolation inside a string.
+type template struct {
+	start  token.Position // position of the string's opening quote
+	open   token.Position // position of the interpolation's "${"
+	braces int            // number of unclosed '{' inside the interpolation
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a lexer whose token positions refer to filename.
+func NewFile(filename, input string) *Lexer {
+	return NewFileReader(filename, strings.NewReader(input))
+}
+
+// NewReader returns a lexer that reads its input from r as needed.
+func NewReader(r io.Reader) *Lexer {
+	return NewFileReader("", r)
+}
+
+// NewFileReader returns a lexer that reads its input from r as needed,
+// and whose token positions refer to filename.
+func NewFileReader(filename string, r io.Reader) *Lexer {
+	l := &Lexer{
+		filename: filename,
+		reader:   bufio.NewReader(r),
+		line:     1,
+	}
 	l.readChar()
 	return l
 }
 
 // readChar advances the lexer by one character.
 func (l *Lexer) readChar() {
-	if l.nextPos >= len(l.input) {
-		l.currChar = 0 // EOF
-	} else {
-		l.currChar = l.input[l.nextPos]
+	if l.currChar == '\n' {
+		l.line += 1
+		l.column = 0
 	}
+	l.column += 1
+
+	if l.inLexeme {
+		l.lexeme = append(l.lexeme, l.currBytes()...)
+	}
+
+	char, raw := l.peekRune()
+	l.currChar = char
+	l.currSize = copy(l.currRaw[:], raw)
+	l.reader.Discard(l.currSize)
+
 	l.currPos = l.nextPos
-	l.nextPos += 1
+	l.nextPos += l.currSize
+}
+
+func (l *Lexer) peekChar() rune {
+	char, _ := l.peekRune()
+	return char
+}
+
+// followedBy reports whether the unread input starts with s.
+func (l *Lexer) followedBy(s string) bool {
+	buf, _ := l.reader.Peek(len(s))
+	return string(buf) == s
+}
+
+// peekRune decodes the character at the front of the unread input without
+// consuming it, and returns it together with its raw bytes. At the end of
+// the input it returns 0 and no bytes.
+func (l *Lexer) peekRune() (rune, []byte) {
+	buf, err := l.reader.Peek(1)
+	if len(buf) == 0 {
+		if err != io.EOF && !l.readFailed {
+			l.readFailed = true
+			l.errorf(ReadError, l.pos(), "could not read input: %v", err)
+		}
+		return 0, nil // EOF
+	}
+
+	if buf[0] < utf8.RuneSelf {
+		return rune(buf[0]), buf
+	}
+
+	// only ask for as many bytes as the rune needs, so that the lexer
+	// doesn't block on input it has no use for yet
+	buf, _ = l.reader.Peek(runeLen(buf[0]))
+	char, size := utf8.DecodeRune(buf)
+	return char, buf[:size]
+}
+
+// runeLen returns the length of the UTF-8 sequence starting with lead.
+func runeLen(lead byte) int {
+	switch {
+	case lead >= 0xF0:
+		return 4
+	case lead >= 0xE0:
+		return 3
+	case lead >= 0xC0:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// currBytes returns the raw bytes of currChar.
+func (l *Lexer) currBytes() []byte {
+	return l.currRaw[:l.currSize]
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.nextPos >= len(l.input) {
-		return 0 // EOF
+// atEOF reports whether the input is exhausted. currChar is then 0, like
+// for a NUL character in the input.
+func (l *Lexer) atEOF() bool {
+	return l.currSize == 0
+}
+
+// peekEOF reports whether the input ends after currChar.
+func (l *Lexer) peekEOF() bool {
+	_, raw := l.peekRune()
+	return len(raw) == 0
+}
+
+// isInvalidChar reports whether currChar stands for a byte that is not
+// valid UTF-8.
+func (l *Lexer) isInvalidChar() bool {
+	return l.currChar == utf8.RuneError && l.currSize == 1
+}
+
+// startLexeme starts collecting the characters beginning with currChar.
+func (l *Lexer) startLexeme() {
+	l.lexeme = l.lexeme[:0]
+	l.inLexeme = true
+}
+
+// lexemeString returns the characters read since the last call to
+// startLexeme, not including currChar, and stops collecting them.
+func (l *Lexer) lexemeString() string {
+	l.inLexeme = false
+	return string(l.lexeme)
+}
+
+// Diagnostics returns the problems found in the input so far.
+func (l *Lexer) Diagnostics() []Diagnostic {
+	return l.diagnostics
+}
+
+func (l *Lexer) errorf(
+	kind DiagnosticKind,
+	pos token.Position,
+	format string,
+	a ...interface{},
+) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		Kind: kind,
+		Pos:  pos,
+		Msg:  fmt.Sprintf(format, a...),
+	})
+}
+
+// pos returns the position of currChar.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.currPos,
+		Line:     l.line,
+		Column:   l.column,
 	}
-	return l.input[l.nextPos]
 }
 
-func newToken(t token.TokenType, ch byte) token.Token {
+// newTwoCharToken returns a token of type t made of currChar and the
+// character following it.
+func (l *Lexer) newTwoCharToken(t token.TokenType) token.Token {
+	ch := l.currChar
+	l.readChar()
+	literal := string(ch) + string(l.currChar)
+	return token.Token{Type: t, Literal: literal}
+}
+
+func newToken(t token.TokenType, ch rune) token.Token {
 	return token.Token{Type: t, Literal: string(ch)}
 }
 
-func isLetter(char byte) bool {
+func isLetter(char rune) bool {
 	return 'a' <= char && char <= 'z' ||
 		'A' <= char && char <= 'Z' ||
-		char == '_'
+		char == '_' ||
+		char >= utf8.RuneSelf && unicode.IsLetter(char)
+}
+
+func isIdentifierChar(char rune) bool {
+	return isLetter(char) || isDigit(char) ||
+		char >= utf8.RuneSelf && unicode.IsDigit(char)
 }
 
-func isDigit(char byte) bool {
+func isDigit(char rune) bool {
 	return '0' <= char && char <= '9'
 }
 
-func isWhitespace(char byte) bool {
+func hexValue(char rune) (int, bool) {
+	switch {
+	case '0' <= char && char <= '9':
+		return int(char - '0'), true
+	case 'a' <= char && char <= 'f':
+		return int(char-'a') + 10, true
+	case 'A' <= char && char <= 'F':
+		return int(char-'A') + 10, true
+	}
+	return 0, false
+}
+
+func isWhitespace(char rune) bool {
 	return char == ' ' ||
 		char == '\t' ||
 		char == '\n' ||
 		char == '\r'
 }
 
+// readIdentifier reads an identifier or keyword. Identifiers start with a
+// letter and continue with letters and digits, where a letter is '_' or
+// any Unicode letter (category L) and a digit is any Unicode decimal digit
+// (category Nd). Number literals, in contrast, only use the ASCII digits.
 func (l *Lexer) readIdentifier() string {
-	startPos := l.currPos
-	for isLetter(l.currChar) {
+	l.startLexeme()
+	for isIdentifierChar(l.currChar) {
+		l.readChar()
+	}
+	return l.lexemeString()
+}
+
+// readNumber reads an integer or floating-point literal. Integers are
+// decimal, or hexadecimal, octal or binary with a 0x, 0o or 0b prefix.
+// A float has a fractional part (a '.' followed by at least one digit),
+// an exponent ('e' or 'E', an optional sign and at least one digit) or
+// both. Underscores may be used to separate digits.
+//
+// Prefixed integers are read up to the first character that can't be
+// part of an identifier, so that the parser can report malformed literals
+// such as 0b102 as a whole.
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	l.startLexeme()
+	tokenType := token.TokenType(token.INT)
+
+	if l.currChar == '0' && isBasePrefix(l.peekChar()) {
+		l.readChar()
+		l.readChar()
+		for isIdentifierChar(l.currChar) {
+			l.readChar()
+		}
+		return l.lexemeString(), tokenType
+	}
+
+	l.readDigits()
+
+	if l.currChar == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.currChar == 'e' || l.currChar == 'E' {
+		tokenType = token.FLOAT
+		exponentPos := l.pos()
+		l.readChar()
+		if l.currChar == '+' || l.currChar == '-' {
+			l.readChar()
+		}
+		if !isDigit(l.currChar) {
+			l.errorf(InvalidNumber, exponentPos, "exponent has no digits")
+		}
+		l.readDigits()
+	}
+
+	return l.lexemeString(), tokenType
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.currChar) || l.currChar == '_' {
+		l.readChar()
+	}
+}
+
+func isBasePrefix(char rune) bool {
+	switch char {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	}
+	return false
+}
+
+// readString reads the part of a double-quoted string literal following
+// currChar, which is either the opening quote or the '}' ending an
+// interpolation. It returns the part's value with escape sequences
+// resolved and, if the part ends with the start of an interpolation "${"
+// rather than with the closing quote, the position of the "${". The
+// supported escape sequences are:
+//
+//	\n \t \r \0 \" \' \\  the usual single-character escapes
+//	\$                  a '$' that doesn't start an interpolation
+//	\xNN                the byte with hex value NN
+//	\uNNNN              the UTF-8 encoding of code point U+NNNN
+//	\u{N...}            the UTF-8 encoding of code point U+N... (1-6 digits)
+//
+// Malformed escape sequences are reported as diagnostics, as are strings
+// missing the closing quote. A string inside an interpolation that is
+// missing the closing quote is reported as the unclosed interpolation, as
+// the quote was most likely meant to end the string around it.
+func (l *Lexer) readString(startPos token.Position) (string, token.Position) {
+	var out strings.Builder
+	for {
 		l.readChar()
+		if l.currChar == '"' {
+			return out.String(), token.Position{}
+		}
+		if l.currChar == '$' && l.peekChar() == '{' {
+			interpolation := l.pos()
+			l.readChar()
+			return out.String(), interpolation
+		}
+		if l.atEOF() {
+			if len(l.templates) > 0 {
+				l.unclosedInterpolation()
+			} else {
+				l.errorf(UnterminatedString, startPos, "unterminated string")
+			}
+			return out.String(), token.Position{}
+		}
+		if l.currChar == '\\' {
+			l.readEscape(&out)
+			continue
+		}
+		// copy the raw bytes, so that invalid UTF-8 is preserved as is
+		out.Write(l.currBytes())
 	}
-	return l.input[startPos:l.currPos]
 }
 
-func (l *Lexer) readNumber() string {
-	startPos := l.currPos
-	for isDigit(l.currChar) {
+// unclosedInterpolation reports that the input ended inside the innermost
+// interpolation, and leaves all of them. The token being read is made
+// ILLEGAL, so that the parser doesn't report the problem again.
+func (l *Lexer) unclosedInterpolation() {
+	open := l.templates[len(l.templates)-1].open
+	l.errorf(UnterminatedString, open, "unclosed interpolation in string")
+	l.templates = nil
+}
+
+// readRawString reads a backtick-delimited string literal. Raw strings
+// may span several lines and have neither escape sequences nor
+// interpolations, so their value is exactly the text between the
+// backticks.
+func (l *Lexer) readRawString() string {
+	startPos := l.pos()
+
+	l.readChar()
+	l.startLexeme()
+	for l.currChar != '`' {
+		if l.atEOF() {
+			l.errorf(UnterminatedString, startPos, "unterminated raw string")
+			break
+		}
 		l.readChar()
 	}
-	return l.input[startPos:l.currPos]
+	return l.lexemeString()
+}
+
+// readStringToken reads a string literal, or the part of one following
+// an interpolation, into tok.
+func (l *Lexer) readStringToken(tok *token.Token, startPos token.Position, isHead bool) {
+	inInterpolation := len(l.templates) > 0
+	value, interpolation := l.readString(startPos)
+	tok.Literal = value
+
+	switch {
+	case l.atEOF() && inInterpolation:
+		// reported as an unclosed interpolation by readString
+		tok.Type = token.ILLEGAL
+	case interpolation.IsValid():
+		l.templates = append(l.templates, template{start: startPos, open: interpolation})
+		if isHead {
+			tok.Type = token.TEMPLATE_HEAD
+		} else {
+			tok.Type = token.TEMPLATE_MIDDLE
+		}
+	case isHead:
+		tok.Type = token.STRING
+	default:
+		tok.Type = token.TEMPLATE_TAIL
+	}
 }
 
-func (l *Lexer) readString() string {
-	//TODO(sbrki): support for basic escape sequences:
-	// \t, \n, \', \", \\
+// readEscape reads the escape sequence starting at the backslash in
+// currChar and writes its value to out. It stops at the last character
+// belonging to the escape sequence.
+func (l *Lexer) readEscape(out *strings.Builder) {
+	startPos := l.pos()
+
+	if l.peekEOF() {
+		// reported as an unterminated string by readString
+		return
+	}
+
+	switch l.peekChar() {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '0':
+		out.WriteByte(0)
+	case '"', '\'', '\\', '$':
+		out.WriteRune(l.peekChar())
+	case 'x':
+		l.readChar()
+		value, digits := l.readHexDigits(2)
+		if digits != 2 {
+			l.errorf(InvalidEscape, startPos, "invalid escape sequence: \\x must be followed by 2 hex digits")
+			return
+		}
+		out.WriteByte(byte(value))
+		return
+	case 'u':
+		l.readChar()
+		l.readUnicodeEscape(out, startPos)
+		return
+	default:
+		l.errorf(InvalidEscape, startPos, "unknown escape sequence: \\%c", l.peekChar())
+	}
 	l.readChar()
-	startPos := l.currPos
-	for l.currChar != '"' && l.currChar != 0 {
+}
+
+// readUnicodeEscape reads the code point of a \uNNNN or \u{N...} escape
+// sequence, with currChar on the 'u'.
+func (l *Lexer) readUnicodeEscape(out *strings.Builder, startPos token.Position) {
+	var value, digits int
+
+	if l.peekChar() == '{' {
+		l.readChar()
+		value, digits = l.readHexDigits(6)
+		if digits == 0 || l.peekChar() != '}' {
+			l.errorf(InvalidEscape, startPos, "invalid escape sequence: \\u{ must be followed by 1 to 6 hex digits and }")
+			return
+		}
+		l.readChar()
+	} else {
+		value, digits = l.readHexDigits(4)
+		if digits != 4 {
+			l.errorf(InvalidEscape, startPos, "invalid escape sequence: \\u must be followed by 4 hex digits")
+			return
+		}
+	}
+
+	r := rune(value)
+	if !utf8.ValidRune(r) {
+		l.errorf(InvalidEscape, startPos, "invalid escape sequence: U+%X is not a valid code point", value)
+		return
+	}
+	out.WriteRune(r)
+}
+
+// readHexDigits reads up to max hex digits following currChar and
+// returns their value and how many were read.
+func (l *Lexer) readHexDigits(max int) (value int, digits int) {
+	for digits < max {
+		digit, ok := hexValue(l.peekChar())
+		if !ok {
+			break
+		}
 		l.readChar()
+		value = value*16 + digit
+		digits += 1
 	}
-	return l.input[startPos:l.currPos]
+	return value, digits
 }
 
 func (l *Lexer) consumeWhitespace() {
@@ -87,19 +565,119 @@ func (l *Lexer) consumeWhitespace() {
 	}
 }
 
+// readLeadingTrivia skips whitespace and returns the comments found
+// before the next token.
+func (l *Lexer) readLeadingTrivia() []token.Trivia {
+	var trivia []token.Trivia
+	for {
+		l.consumeWhitespace()
+		if l.currChar != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			return trivia
+		}
+		trivia = append(trivia, l.readComment())
+	}
+}
+
+// readTrailingTrivia returns the comments following the current token on
+// the same line. It doesn't consume the line break.
+func (l *Lexer) readTrailingTrivia() []token.Trivia {
+	var trivia []token.Trivia
+	for {
+		for l.currChar == ' ' || l.currChar == '\t' {
+			l.readChar()
+		}
+		if l.currChar != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			return trivia
+		}
+		comment := l.readComment()
+		trivia = append(trivia, comment)
+		if comment.Type == token.LINE_COMMENT {
+			return trivia
+		}
+	}
+}
+
+// readComment reads the comment starting at currChar. Line comments run
+// from // to the end of the line. Block comments run from /* to the
+// matching */ and nest, so that /* a /* b */ c */ is a single comment and
+// code containing block comments can itself be commented out.
+func (l *Lexer) readComment() token.Trivia {
+	comment := token.Trivia{Pos: l.pos()}
+	l.startLexeme()
+
+	l.readChar()
+	if l.currChar == '/' {
+		comment.Type = token.LINE_COMMENT
+		for l.peekChar() != '\n' && l.peekChar() != '\r' && !l.peekEOF() {
+			l.readChar()
+		}
+	} else {
+		comment.Type = token.BLOCK_COMMENT
+		depth := 1
+		for depth > 0 {
+			l.readChar()
+			switch {
+			case l.atEOF():
+				l.errorf(UnterminatedComment, comment.Pos, "unterminated block comment")
+				comment.Text = l.lexemeString()
+				comment.End = l.pos()
+				return comment
+			case l.currChar == '/' && l.peekChar() == '*':
+				l.readChar()
+				depth += 1
+			case l.currChar == '*' && l.peekChar() == '/':
+				l.readChar()
+				depth -= 1
+			}
+		}
+	}
+	l.readChar()
+
+	comment.Text = l.lexemeString()
+	comment.End = l.pos()
+	return comment
+}
+
+// NextToken returns the next token in the input, together with the
+// comments surrounding it. Once the input is exhausted, it returns EOF
+// tokens.
 func (l *Lexer) NextToken() token.Token {
+	leading := l.readLeadingTrivia()
+
+	tok := l.readToken()
+	tok.LeadingTrivia = leading
+	if tok.Type != token.EOF {
+		tok.TrailingTrivia = l.readTrailingTrivia()
+	}
+
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
 
-	l.consumeWhitespace()
+	startPos := l.pos()
+
+	if l.atEOF() {
+		if len(l.templates) > 0 {
+			// EOF follows with the next call
+			l.unclosedInterpolation()
+			return token.Token{Type: token.ILLEGAL, Pos: startPos, End: startPos}
+		}
+		tok = newToken(token.EOF, 0)
+		tok.Pos = startPos
+		tok.End = startPos
+		return tok // don't advance past EOF
+	}
 
 	switch l.currChar {
 	case '=':
-		if l.peekChar() == '=' {
-			ch := l.currChar
-			l.readChar()
-			literal := string(ch) + string(l.currChar)
-			tok = token.Token{Type: token.EQ, Literal: literal}
-		} else {
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.EQ)
+		case '>':
+			tok = l.newTwoCharToken(token.ARROW)
+		default:
 			tok = newToken(token.ASSIGN, l.currChar)
 		}
 	case '+':
@@ -108,59 +686,125 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.MINUS, l.currChar)
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.currChar
-			l.readChar()
-			literal := string(ch) + string(l.currChar)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
+			tok = l.newTwoCharToken(token.NOT_EQ)
 		} else {
 			tok = newToken(token.BANG, l.currChar)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.currChar)
+		if l.peekChar() == '*' {
+			tok = l.newTwoCharToken(token.POWER)
+		} else {
+			tok = newToken(token.ASTERISK, l.currChar)
+		}
 	case '/':
 		tok = newToken(token.SLASH, l.currChar)
+	case '%':
+		tok = newToken(token.PERCENT, l.currChar)
 	case '<':
-		tok = newToken(token.LT, l.currChar)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.newTwoCharToken(token.LSHIFT)
+		default:
+			tok = newToken(token.LT, l.currChar)
+		}
 	case '>':
-		tok = newToken(token.GT, l.currChar)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.newTwoCharToken(token.RSHIFT)
+		default:
+			tok = newToken(token.GT, l.currChar)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.newTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.AMPERSAND, l.currChar)
+		}
+	case '|':
+		switch l.peekChar() {
+		case '|':
+			tok = l.newTwoCharToken(token.OR)
+		case '>':
+			tok = l.newTwoCharToken(token.PIPELINE)
+		default:
+			tok = newToken(token.PIPE, l.currChar)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.currChar)
+	case '~':
+		tok = newToken(token.TILDE, l.currChar)
 	case ',':
 		tok = newToken(token.COMMA, l.currChar)
+	case '.':
+		if l.followedBy("..") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.DOT, l.currChar)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.currChar)
 	case ':':
-		tok  = newToken(token.COLON, l.currChar)
+		tok = newToken(token.COLON, l.currChar)
 	case '(':
 		tok = newToken(token.LPAREN, l.currChar)
 	case ')':
 		tok = newToken(token.RPAREN, l.currChar)
 	case '{':
+		if len(l.templates) > 0 {
+			l.templates[len(l.templates)-1].braces += 1
+		}
 		tok = newToken(token.LBRACE, l.currChar)
 	case '}':
+		if len(l.templates) > 0 {
+			innermost := &l.templates[len(l.templates)-1]
+			if innermost.braces == 0 {
+				// end of the interpolation, the string continues
+				l.templates = l.templates[:len(l.templates)-1]
+				l.readStringToken(&tok, innermost.start, false)
+				break
+			}
+			innermost.braces -= 1
+		}
 		tok = newToken(token.RBRACE, l.currChar)
 	case '[':
 		tok = newToken(token.LBRACKET, l.currChar)
 	case ']':
 		tok = newToken(token.RBRACKET, l.currChar)
 	case '"':
+		l.readStringToken(&tok, startPos, true)
+	case '`':
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
-	case 0:
-		tok = newToken(token.EOF, 0)
-
+		tok.Literal = l.readRawString()
 	default:
 		if isLetter(l.currChar) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
-			return tok // don't advance the lexer! readIdentifier stops at first non-letter char
+			tok.Pos = startPos
+			tok.End = l.pos()
+			return tok // don't advance the lexer! readIdentifier stops at first non-identifier char
 		} else if isDigit(l.currChar) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = startPos
+			tok.End = l.pos()
 			return tok // don't advance the lexer! readNumber stops at first non-num char
+		} else if l.isInvalidChar() {
+			l.errorf(InvalidEncoding, startPos, "invalid UTF-8 encoding")
+			tok.Type = token.ILLEGAL
+			tok.Literal = string(l.currBytes())
 		} else {
+			l.errorf(IllegalCharacter, startPos, "illegal character %q", l.currChar)
 			tok = newToken(token.ILLEGAL, l.currChar)
 		}
 	}
 
 	l.readChar()
+	tok.Pos = startPos
+	tok.End = l.pos()
 	return tok
 }
@@ -1,7 +1,10 @@
 package lexer
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/sbrki/monkey/pkg/token"
 )
@@ -15,7 +18,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5
+!-/ *5
 5 < 10 > 5
 
 if (5 < 10) {
@@ -78,7 +81,7 @@ if (5 < 10) {
 		{token.IDENT, "ten"},
 		{token.RPAREN, ")"},
 		{token.SEMICOLON, ";"},
-		// !-/*5
+		// !-/ *5
 		{token.BANG, "!"},
 		{token.MINUS, "-"},
 		{token.SLASH, "/"},
@@ -157,3 +160,530 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 10;
+  "ab" != x`
+
+	pos := func(offset, line, column int) token.Position {
+		return token.Position{Filename: "test.mk", Offset: offset, Line: line, Column: column}
+	}
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.LET, pos(0, 1, 1), pos(3, 1, 4)},
+		{token.IDENT, pos(4, 1, 5), pos(5, 1, 6)},
+		{token.ASSIGN, pos(6, 1, 7), pos(7, 1, 8)},
+		{token.INT, pos(8, 1, 9), pos(10, 1, 11)},
+		{token.SEMICOLON, pos(10, 1, 11), pos(11, 1, 12)},
+		{token.STRING, pos(14, 2, 3), pos(18, 2, 7)},
+		{token.NOT_EQ, pos(19, 2, 8), pos(21, 2, 10)},
+		{token.IDENT, pos(22, 2, 11), pos(23, 2, 12)},
+		{token.EOF, pos(23, 2, 12), pos(23, 2, 12)},
+	}
+
+	l := NewFile("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%+v, got=%+v",
+				i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. expected=%+v, got=%+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue string
+	}{
+		{`"a\tb\nc\rd"`, "a\tb\nc\rd"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"it\'s"`, "it's"},
+		{`"back\\slash"`, `back\slash`},
+		{`"nul\0"`, "nul\x00"},
+		{"\"raw\x00nul\"", "raw\x00nul"},
+		{`"\x41\x7a"`, "Az"},
+		{`"été"`, "été"},
+		{`"\u{1F600}"`, "\U0001F600"},
+		{`"\u{41}"`, "A"},
+		{`"\${x}"`, "${x}"},
+		{`"$x {y}"`, "$x {y}"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.STRING {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, token.STRING, tok.Type)
+		}
+		if tok.Literal != tt.expectedValue {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedValue, tok.Literal)
+		}
+		if len(l.Diagnostics()) != 0 {
+			t.Errorf("tests[%d] - unexpected diagnostics: %v", i, l.Diagnostics())
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("tests[%d] - expected EOF after string, got=%q", i, next.Type)
+		}
+	}
+}
+
+func TestTemplateStrings(t *testing.T) {
+	input := `"a${x}b${ {"k": "${y}"} }c" "${1}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.TEMPLATE_HEAD, "a"},
+		{token.IDENT, "x"},
+		{token.TEMPLATE_MIDDLE, "b"},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.TEMPLATE_HEAD, ""},
+		{token.IDENT, "y"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.RBRACE, "}"},
+		{token.TEMPLATE_TAIL, "c"},
+		{token.TEMPLATE_HEAD, ""},
+		{token.INT, "1"},
+		{token.TEMPLATE_TAIL, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+	if len(l.Diagnostics()) != 0 {
+		t.Errorf("unexpected diagnostics: %v", l.Diagnostics())
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	input := "`line one\n  \\n \"${x}\"\nline two` `unterminated"
+
+	l := New(input)
+
+	tok := l.NextToken()
+	if tok.Type != token.STRING {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.STRING, tok.Type)
+	}
+	expected := "line one\n  \\n \"${x}\"\nline two"
+	if tok.Literal != expected {
+		t.Errorf("literal wrong. expected=%q, got=%q", expected, tok.Literal)
+	}
+	if tok.End.String() != "3:10" {
+		t.Errorf("end wrong. expected=3:10, got=%s", tok.End)
+	}
+
+	tok = l.NextToken()
+	if tok.Type != token.STRING || tok.Literal != "unterminated" {
+		t.Errorf("unterminated raw string wrong, got=%q %q", tok.Type, tok.Literal)
+	}
+
+	diagnostics := l.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got=%v", diagnostics)
+	}
+	if diagnostics[0].String() != "3:11: unterminated raw string" {
+		t.Errorf("wrong diagnostic, got=%q", diagnostics[0].String())
+	}
+}
+
+func TestStringEscapeDiagnostics(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{`"a\qb"`, `1:3: unknown escape sequence: \q`},
+		{`"\x4"`, `1:2: invalid escape sequence: \x must be followed by 2 hex digits`},
+		{`"\u12"`, `1:2: invalid escape sequence: \u must be followed by 4 hex digits`},
+		{`"\u{}"`, `1:2: invalid escape sequence: \u{ must be followed by 1 to 6 hex digits and }`},
+		{`"\u{1234567}"`, `1:2: invalid escape sequence: \u{ must be followed by 1 to 6 hex digits and }`},
+		{`"\u{110000}"`, `1:2: invalid escape sequence: U+110000 is not a valid code point`},
+		{`"\uD800"`, `1:2: invalid escape sequence: U+D800 is not a valid code point`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		l.NextToken()
+
+		diagnostics := l.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Errorf("tests[%d] - expected 1 diagnostic, got=%v", i, diagnostics)
+			continue
+		}
+		if diagnostics[0].String() != tt.expectedMessage {
+			t.Errorf("tests[%d] - diagnostic wrong. expected=%q, got=%q",
+				i, tt.expectedMessage, diagnostics[0].String())
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := `let größe = "größe"; 名前 + x1 + _tmp٣`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "größe", 5},
+		{token.ASSIGN, "=", 11},
+		{token.STRING, "größe", 13},
+		{token.SEMICOLON, ";", 20},
+		{token.IDENT, "名前", 22},
+		{token.PLUS, "+", 25},
+		{token.IDENT, "x1", 27},
+		{token.PLUS, "+", 30},
+		{token.IDENT, "_tmp٣", 32},
+		{token.EOF, "\x00", 37},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - column wrong. expected=%d, got=%d",
+				i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	l := New("a \xff b")
+
+	tests := []token.TokenType{token.IDENT, token.ILLEGAL, token.IDENT, token.EOF}
+	for i, expectedType := range tests {
+		tok := l.NextToken()
+		if tok.Type != expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, expectedType, tok.Type)
+		}
+	}
+
+	diagnostics := l.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].String() != "1:3: invalid UTF-8 encoding" {
+		t.Errorf("wrong diagnostics, got=%v", diagnostics)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+let x = 1; // one
+/* a /* nested */ block */ x /* inline */ + y // sum
+// footer`
+
+	l := New(input)
+
+	type trivia struct {
+		leading  []string
+		trailing []string
+	}
+
+	tests := []struct {
+		expectedType token.TokenType
+		expected     trivia
+	}{
+		{token.LET, trivia{leading: []string{"// header"}}},
+		{token.IDENT, trivia{}},
+		{token.ASSIGN, trivia{}},
+		{token.INT, trivia{}},
+		{token.SEMICOLON, trivia{trailing: []string{"// one"}}},
+		{token.IDENT, trivia{
+			leading:  []string{"/* a /* nested */ block */"},
+			trailing: []string{"/* inline */"},
+		}},
+		{token.PLUS, trivia{}},
+		{token.IDENT, trivia{trailing: []string{"// sum"}}},
+		{token.EOF, trivia{leading: []string{"// footer"}}},
+	}
+
+	texts := func(trivia []token.Trivia) []string {
+		var out []string
+		for _, tr := range trivia {
+			out = append(out, tr.Text)
+		}
+		return out
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if fmt.Sprint(texts(tok.LeadingTrivia)) != fmt.Sprint(tt.expected.leading) {
+			t.Errorf("tests[%d] - leading trivia wrong. expected=%q, got=%q",
+				i, tt.expected.leading, texts(tok.LeadingTrivia))
+		}
+		if fmt.Sprint(texts(tok.TrailingTrivia)) != fmt.Sprint(tt.expected.trailing) {
+			t.Errorf("tests[%d] - trailing trivia wrong. expected=%q, got=%q",
+				i, tt.expected.trailing, texts(tok.TrailingTrivia))
+		}
+	}
+
+	if len(l.Diagnostics()) != 0 {
+		t.Errorf("unexpected diagnostics: %v", l.Diagnostics())
+	}
+}
+
+func TestCommentPositions(t *testing.T) {
+	l := New("x /* a\nb */ // c")
+	tok := l.NextToken()
+
+	if len(tok.TrailingTrivia) != 2 {
+		t.Fatalf("expected 2 trailing comments, got=%d", len(tok.TrailingTrivia))
+	}
+
+	block := tok.TrailingTrivia[0]
+	if block.Type != token.BLOCK_COMMENT || block.Pos.String() != "1:3" || block.End.String() != "2:5" {
+		t.Errorf("block comment wrong, got=%+v", block)
+	}
+
+	line := tok.TrailingTrivia[1]
+	if line.Type != token.LINE_COMMENT || line.Pos.String() != "2:6" || line.End.String() != "2:10" {
+		t.Errorf("line comment wrong, got=%+v", line)
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("x /* a /* b */")
+	l.NextToken()
+
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("expected EOF, got=%q", tok.Type)
+	}
+
+	diagnostics := l.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].String() != "1:3: unterminated block comment" {
+		t.Errorf("wrong diagnostics, got=%v", diagnostics)
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	input := `5 3.14 1e-9 2.5E+3 7e2 1.foo 0.5;`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.FLOAT, "7e2"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "foo"},
+		{token.FLOAT, "0.5"},
+		{token.SEMICOLON, ";"},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if diagnostics := l.Diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+}
+
+func TestMalformedExponent(t *testing.T) {
+	l := New("1e+")
+	tok := l.NextToken()
+
+	if tok.Type != token.FLOAT || tok.Literal != "1e+" {
+		t.Fatalf("wrong token, got=%q (%q)", tok.Type, tok.Literal)
+	}
+
+	diagnostics := l.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].String() != "1:2: exponent has no digits" {
+		t.Errorf("wrong diagnostics, got=%v", diagnostics)
+	}
+}
+
+func TestPrefixedNumberLiterals(t *testing.T) {
+	input := `0x1F 0o17 0b1010 1_000_000 1_000.5 0b102 0x1G+1`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "0x1F"},
+		{token.INT, "0o17"},
+		{token.INT, "0b1010"},
+		{token.INT, "1_000_000"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0b102"},
+		{token.INT, "0x1G"},
+		{token.PLUS, "+"},
+		{token.INT, "1"},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := `a <= b >= c % d ** e & f | g ^ ~h << i >> j < k > l * m && n || o ...p q.r s => t |> u`
+
+	expected := []token.TokenType{
+		token.IDENT, token.LT_EQ, token.IDENT, token.GT_EQ, token.IDENT,
+		token.PERCENT, token.IDENT, token.POWER, token.IDENT,
+		token.AMPERSAND, token.IDENT, token.PIPE, token.IDENT,
+		token.CARET, token.TILDE, token.IDENT, token.LSHIFT, token.IDENT,
+		token.RSHIFT, token.IDENT, token.LT, token.IDENT, token.GT,
+		token.IDENT, token.ASTERISK, token.IDENT, token.AND, token.IDENT,
+		token.OR, token.IDENT, token.ELLIPSIS, token.IDENT,
+		token.IDENT, token.DOT, token.IDENT, token.IDENT, token.ARROW,
+		token.IDENT, token.PIPELINE, token.IDENT, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, expectedType := range expected {
+		tok := l.NextToken()
+		if tok.Type != expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q (%q)",
+				i, expectedType, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	input := `let größe = fn(x) { x ** 2 }; // comment
+"esc\"aped \u{1F600}" /* block */ 0x1F 3.5e2 名前`
+
+	expected := New(input)
+	l := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+
+	for i := 0; ; i++ {
+		want := expected.NextToken()
+		got := l.NextToken()
+
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+			t.Fatalf("tokens[%d] differ. expected=%+v, got=%+v", i, want, got)
+		}
+		if want.Type == token.EOF {
+			break
+		}
+	}
+}
+
+func TestNewReaderError(t *testing.T) {
+	l := NewReader(iotest.TimeoutReader(strings.NewReader("let x")))
+
+	// TimeoutReader fails on the second read
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	diagnostics := l.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Msg != "could not read input: timeout" {
+		t.Errorf("wrong diagnostics, got=%v", diagnostics)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKind DiagnosticKind
+		expectedText string
+	}{
+		{"let s = \"abc", UnterminatedString, "1:9: unterminated string"},
+		{"\"abc\\", UnterminatedString, "1:1: unterminated string"},
+		{"\"a${x\"", UnterminatedString, "1:3: unclosed interpolation in string"},
+		{"x\n  # y", IllegalCharacter, "2:3: illegal character '#'"},
+		{"a @ b", IllegalCharacter, "1:3: illegal character '@'"},
+		{"\"\\q\"", InvalidEscape, "1:2: unknown escape sequence: \\q"},
+		{"1e", InvalidNumber, "1:2: exponent has no digits"},
+		{"/* a", UnterminatedComment, "1:1: unterminated block comment"},
+		{"\xff", InvalidEncoding, "1:1: invalid UTF-8 encoding"},
+		// NUL is an illegal character, not the end of the input
+		{"let a = 1;\x00 let b = 2;", IllegalCharacter, "1:11: illegal character '\\x00'"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		diagnostics := l.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Errorf("tests[%d] - expected 1 diagnostic, got=%v", i, diagnostics)
+			continue
+		}
+		if diagnostics[0].Kind != tt.expectedKind {
+			t.Errorf("tests[%d] - kind wrong. expected=%s, got=%s",
+				i, tt.expectedKind, diagnostics[0].Kind)
+		}
+		if diagnostics[0].String() != tt.expectedText {
+			t.Errorf("tests[%d] - diagnostic wrong. expected=%q, got=%q",
+				i, tt.expectedText, diagnostics[0].String())
+		}
+	}
+}
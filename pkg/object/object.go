@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"strconv"
 	"strings"
 
 	"github.com/sbrki/monkey/pkg/ast"
+	"github.com/sbrki/monkey/pkg/token"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	STRING_OBJ       = "STRING"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	ARRAY_OBJ        = "ARRAY"
@@ -20,8 +23,13 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	HASH_OBJ         = "HASH"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
+	MODULE_OBJ       = "MODULE"
 )
 
 type Object interface {
@@ -52,6 +60,24 @@ func (i *Integer) HashKey() HashKey {
 	}
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect formats the float so that it reads back as the same float,
+// which means it always has a '.' or an exponent (1.0, not 1). The
+// exceptions are infinities and NaN, which have no literal form: they
+// are printed as +Inf, -Inf and NaN, which don't read back.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // IN for Inf and NaN
+		s += ".0"
+	}
+	return s
+}
+
 type String struct {
 	Value string
 }
@@ -111,7 +137,9 @@ func (a *Array) Inspect() string {
 }
 
 type Function struct {
-	Parameters []*ast.Identifier
+	Name       string // may be empty
+	Parameters []ast.Pattern
+	Rest       *ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -124,8 +152,14 @@ func (f *Function) Inspect() string {
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
 
 	out.WriteString("fn")
+	if f.Name != "" {
+		out.WriteString(" " + f.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ","))
 	out.WriteString(") {\n")
@@ -135,6 +169,57 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Composition is the function f >> g >> ..., which passes its arguments
+// to the first function and the result of each function to the next.
+// It can be used wherever a Function can.
+type Composition struct {
+	Functions []Object // functions, builtins or compositions
+}
+
+func (c *Composition) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Composition) Inspect() string {
+	functions := []string{}
+	for _, fn := range c.Functions {
+		functions = append(functions, fn.Inspect())
+	}
+	return strings.Join(functions, " >> ")
+}
+
+// Quote is unevaluated syntax, as returned by quote(...).
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string {
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ","))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
 type BuiltinFunction func(args ...Object) Object
 type Builtin struct {
 	Fn BuiltinFunction
@@ -155,12 +240,60 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue are the results of break and continue statements.
+// Like ReturnValue, they stop the evaluation of the blocks they are in,
+// up to the enclosing loop.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Error is a runtime error or a value raised by a throw statement. Errors
+// unwind the evaluation up to the nearest enclosing try statement.
 type Error struct {
 	Message string
+	Kind    string         // e.g. "RuntimeError", or the kind given to throw
+	Pos     token.Position // where the error was raised, if known
+	Value   Object         // the thrown value, nil for runtime errors
+	// Stack holds the function calls the error passed through, innermost
+	// first.
+	Stack []Frame
+}
+
+// Frame is a call of a function.
+type Frame struct {
+	Function string // "" for anonymous functions
+	Call     token.Position
+}
+
+func (f Frame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "anonymous function"
+	}
+	return fmt.Sprintf("in %s, called at %s", name, f.Call)
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString("ERROR: ")
+	if e.Pos.IsValid() {
+		out.WriteString(e.Pos.String() + ": ")
+	}
+	out.WriteString(e.Message)
+	for _, frame := range e.Stack {
+		out.WriteString("\n\t" + frame.String())
+	}
+
+	return out.String()
+}
 
 type HashPair struct {
 	Key   Object
@@ -174,6 +307,22 @@ type Hash struct {
 	// it would be possible to just use Key.HashKey for the key
 	// in Pairs map.
 	Pairs map[HashKey]HashPair
+	// Keys holds the keys of Pairs in insertion order.
+	Keys []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// Set sets the value of key. A new key is added after the existing ones,
+// while setting an existing key keeps its place.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashed := key.HashKey()
+	if _, ok := h.Pairs[hashed]; !ok {
+		h.Keys = append(h.Keys, hashed)
+	}
+	h.Pairs[hashed] = HashPair{Key: key.(Object), Value: value}
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -183,7 +332,8 @@ func (h *Hash) Inspect() string {
 
 	pairs := []string{}
 
-	for _, pair := range h.Pairs {
+	for _, key := range h.Keys {
+		pair := h.Pairs[key]
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect(),
 		))
@@ -195,3 +345,13 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+// Module is an imported file. Only the names it exports are visible to
+// the importing program.
+type Module struct {
+	Path    string // absolute path of the file
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "module " + m.Path }
@@ -39,3 +39,34 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with different content have same hash keys")
 	}
 }
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("b", &Integer{Value: 2})
+
+	if !inner.Assign("a", &Integer{Value: 3}) {
+		t.Fatalf("Assign of outer binding failed")
+	}
+	if _, ok := inner.store["a"]; ok {
+		t.Errorf("Assign created a binding in the inner scope")
+	}
+	if val, _ := outer.Get("a"); val.(*Integer).Value != 3 {
+		t.Errorf("outer binding not updated, got=%s", val.Inspect())
+	}
+
+	if !inner.Assign("b", &Integer{Value: 4}) {
+		t.Fatalf("Assign of inner binding failed")
+	}
+	if _, ok := outer.Get("b"); ok {
+		t.Errorf("Assign created a binding in the outer scope")
+	}
+
+	if inner.Assign("c", &Integer{Value: 5}) {
+		t.Errorf("Assign of undeclared name succeeded")
+	}
+	if _, ok := inner.Get("c"); ok {
+		t.Errorf("Assign of undeclared name created a binding")
+	}
+}
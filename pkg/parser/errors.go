@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sbrki/monkey/pkg/token"
+)
+
+// Error is a syntax error found while parsing.
+type Error struct {
+	Pos      token.Position
+	Token    token.Token       // the offending token, if any
+	Expected []token.TokenType // the token types that would have been valid, if known
+	Msg      string
+}
+
+// Error returns the error's position followed by its message.
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Msg
+	}
+	return e.Msg
+}
+
+// Excerpt returns the line of src the error is on, followed by a line
+// with a caret pointing at the error's column. It returns "" if the
+// error has no position or src doesn't have that line.
+func (e *Error) Excerpt(src string) string {
+	if !e.Pos.IsValid() || e.Pos.Line < 1 {
+		return ""
+	}
+
+	lines := strings.Split(src, "\n")
+	if e.Pos.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimSuffix(lines[e.Pos.Line-1], "\r")
+
+	// Indent the caret with the same whitespace as the line, so that
+	// tabs line up.
+	var caret strings.Builder
+	for i, char := range line {
+		if utf8.RuneCountInString(line[:i]) >= e.Pos.Column-1 {
+			break
+		}
+		if char == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+
+	return line + "\n" + caret.String()
+}
+
+// ErrorList is a list of parser errors.
+type ErrorList []*Error
+
+func (el ErrorList) Len() int      { return len(el) }
+func (el ErrorList) Swap(i, j int) { el[i], el[j] = el[j], el[i] }
+func (el ErrorList) Less(i, j int) bool {
+	a, b := el[i].Pos, el[j].Pos
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	if a.Column != b.Column {
+		return a.Column < b.Column
+	}
+	return el[i].Msg < el[j].Msg
+}
+
+// Sort sorts the list by position, and errors at the same position by
+// message.
+func (el ErrorList) Sort() {
+	sort.Stable(el)
+}
+
+// RemoveDuplicates sorts the list and removes all but the first of
+// errors with the same position and message.
+func (el *ErrorList) RemoveDuplicates() {
+	el.Sort()
+
+	var last *Error
+	i := 0
+	for _, e := range *el {
+		if last == nil || e.Pos != last.Pos || e.Msg != last.Msg {
+			(*el)[i] = e
+			i++
+		}
+		last = e
+	}
+	*el = (*el)[:i]
+}
+
+// Error returns the first error's message, followed by the number of
+// remaining errors.
+func (el ErrorList) Error() string {
+	switch len(el) {
+	case 0:
+		return "no errors"
+	case 1:
+		return el[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", el[0], len(el)-1)
+}
+
+// Err returns the list as an error, or nil if it is empty.
+func (el ErrorList) Err() error {
+	if len(el) == 0 {
+		return nil
+	}
+	return el
+}
@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/sbrki/monkey/pkg/ast"
 	"github.com/sbrki/monkey/pkg/lexer"
@@ -12,26 +14,55 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
+	PIPELINE    // |>
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==, !=
-	LESSGREATER // <, >
+	LESSGREATER // <, >, <=, >=
+	BIT_OR      // |
+	BIT_XOR     // ^
+	BIT_AND     // &
+	SHIFT       // <<, >>
 	SUM         // +, -
-	PRODUCT     // *, /
-	PREFIX      // -X, !X
+	PRODUCT     // *, /, %
+	PREFIX      // -X, !X, ~X
+	POWER       // X ** Y, binds tighter than prefix operators: -2 ** 2 == -4
 	CALL        // foo(X)
-	INDEX       // array[index]
+	INDEX       // array[index], module.member
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:    ASSIGN,
+	token.PIPELINE:  PIPELINE,
+	token.OR:        LOGICAL_OR,
+	token.AND:       LOGICAL_AND,
+	token.EQ:        EQUALS,
+	token.NOT_EQ:    EQUALS,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.LT_EQ:     LESSGREATER,
+	token.GT_EQ:     LESSGREATER,
+	token.PIPE:      BIT_OR,
+	token.CARET:     BIT_XOR,
+	token.AMPERSAND: BIT_AND,
+	token.LSHIFT:    SHIFT,
+	token.RSHIFT:    SHIFT,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.SLASH:     PRODUCT,
+	token.ASTERISK:  PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.POWER:     POWER,
+	token.LPAREN:    CALL,
+	token.LBRACKET:  INDEX,
+	token.DOT:       INDEX,
+}
+
+// rightAssociative holds the infix operators that group right to left,
+// e.g. 2 ** 3 ** 2 == 2 ** (3 ** 2).
+var rightAssociative = map[token.TokenType]bool{
+	token.POWER: true,
 }
 
 type (
@@ -41,7 +72,23 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors ErrorList
+
+	// number of lexer diagnostics already copied to errors
+	lexerDiagnostics int
+
+	// braces is the number of unclosed '{' up to and including
+	// currToken, blockDepth its value at the start of the innermost
+	// block statement being parsed
+	braces     int
+	blockDepth int
+
+	// number of loops around currToken in the current function
+	loopDepth int
+
+	// whether the pattern being parsed may contain literals, as in match
+	// arms
+	literalPatterns bool
 
 	currToken token.Token
 	peekToken token.Token
@@ -53,20 +100,26 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: ErrorList{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_HEAD, p.parseTemplateLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
@@ -75,12 +128,26 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.LSHIFT, p.parseInfixExpression)
+	p.registerInfix(token.RSHIFT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PIPELINE, p.parsePipeExpression)
+	p.registerInfix(token.AND, p.parseLogicalExpression)
+	p.registerInfix(token.OR, p.parseLogicalExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	// read two tokens, so that currToken and peekToken are set
 	p.nextToken()
@@ -88,13 +155,41 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
-func (p *Parser) Errors() []string {
+// Errors returns the syntax errors found so far, including the lexer's
+// diagnostics, in the order they were found.
+func (p *Parser) Errors() ErrorList {
 	return p.errors
 }
 
 func (p *Parser) nextToken() {
 	p.currToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.currToken.Type {
+	case token.LBRACE:
+		p.braces += 1
+	case token.RBRACE:
+		if p.braces > 0 {
+			p.braces -= 1
+		}
+	}
+
+	diagnostics := p.l.Diagnostics()
+	for _, d := range diagnostics[p.lexerDiagnostics:] {
+		p.errors = append(p.errors, &Error{Pos: d.Pos, Msg: d.Msg})
+	}
+	p.lexerDiagnostics = len(diagnostics)
+}
+
+// lexerReported reports whether the lexer already has a diagnostic
+// inside tok, such as a malformed exponent in a float literal.
+func (p *Parser) lexerReported(tok token.Token) bool {
+	for _, d := range p.l.Diagnostics() {
+		if tok.Pos.Offset <= d.Pos.Offset && d.Pos.Offset < tok.End.Offset {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -109,22 +204,129 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 
-	for p.currToken.Type != token.EOF {
-		stmt := p.parseStatement()
+	for !p.Done() {
+		stmt := p.ParseNextStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
-		p.nextToken()
 	}
 	return program
 }
 
+// Done reports whether all the input has been parsed.
+func (p *Parser) Done() bool {
+	return p.curTokenIs(token.EOF)
+}
+
+// ParseNextStatement parses the next top-level statement of the program.
+// Unlike ParseProgram, it allows processing a program while the lexer is
+// still reading it. It returns nil if a syntax error made the parser
+// skip the statement; errors that don't stop the parsing of a statement,
+// such as an invalid number literal, are recorded and the statement is
+// still returned.
+func (p *Parser) ParseNextStatement() ast.Statement {
+	stmt, synced := p.parseStatementSync()
+	if !synced {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// bailout is the panic value used to abandon a statement after a syntax
+// error.
+type bailout struct{}
+
+// statementStart holds the tokens that can only start a statement, and
+// therefore are safe points to resume parsing at after a syntax error.
+var statementStart = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.IMPORT:   true,
+	token.EXPORT:   true,
+	token.THROW:    true,
+	token.TRY:      true,
+}
+
+// parseStatementSync parses the statement starting at currToken like
+// parseStatement. If a syntax error abandons the statement, it skips to
+// the start of the next statement instead and returns nil, true.
+func (p *Parser) parseStatementSync() (stmt ast.Statement, synced bool) {
+	start := p.currToken
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			p.synchronize(start)
+			stmt, synced = nil, true
+		}
+	}()
+
+	return p.parseStatement(), false
+}
+
+// synchronize skips the rest of the statement that starts with the token
+// start, stopping after a ';' or before a statement keyword, the '}'
+// ending the enclosing block, or EOF. Tokens inside nested braces are
+// skipped, so that parsing doesn't resume in the middle of a function
+// body.
+func (p *Parser) synchronize(start token.Token) {
+	for {
+		nested := p.braces > p.blockDepth
+		switch {
+		case p.curTokenIs(token.EOF):
+			return
+		case p.curTokenIs(token.SEMICOLON) && !nested:
+			p.nextToken()
+			return
+		case p.curTokenIs(token.RBRACE) && p.braces < p.blockDepth:
+			return
+		case statementStart[p.currToken.Type] && !nested && p.currToken.Pos != start.Pos:
+			return
+		case p.curTokenIs(token.FUNCTION) && p.peekTokenIs(token.IDENT) &&
+			!nested && p.currToken.Pos != start.Pos:
+			// a function declaration
+			return
+		}
+		p.nextToken()
+	}
+}
+
+// parseStatement parses the statement starting at currToken. A syntax
+// error that abandons the statement panics with bailout.
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.currToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.FUNCTION:
+		if !p.peekTokenIs(token.IDENT) {
+			// a function literal used as an expression
+			return p.parseExpressionStatement()
+		}
+		return p.parseFunctionStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -135,23 +337,23 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		Token: p.currToken,
 	}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
+	p.nextToken()
 
-	letStmt.Name = &ast.Identifier{
-		Token: p.currToken,
-		Value: p.currToken.Literal,
-	}
+	letStmt.Name = p.parsePattern()
 
-	if !p.expectPeek(token.ASSIGN) {
-		return nil
-	}
+	p.expectPeek(token.ASSIGN)
 
 	p.nextToken()
 
 	letStmt.Value = p.parseExpression(LOWEST)
 
+	// let f = fn() { ... } names the function f
+	if fn, ok := letStmt.Value.(*ast.FunctionLiteral); ok && fn.Name == "" {
+		if ident, ok := letStmt.Name.(*ast.Identifier); ok {
+			fn.Name = ident.Value
+		}
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -175,6 +377,55 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return returnStmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	throwStmt := &ast.ThrowStatement{Token: p.currToken}
+
+	p.nextToken()
+
+	throwStmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return throwStmt
+}
+
+// parseTryStatement parses try { ... } catch (e) { ... } finally { ... },
+// in which either the catch or the finally block may be left out.
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	tryStmt := &ast.TryStatement{Token: p.currToken}
+
+	p.expectPeek(token.LBRACE)
+	tryStmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		p.expectPeek(token.LPAREN)
+		p.expectPeek(token.IDENT)
+		tryStmt.Param = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		p.expectPeek(token.RPAREN)
+		p.expectPeek(token.LBRACE)
+		tryStmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		p.expectPeek(token.LBRACE)
+		tryStmt.Finally = p.parseBlockStatement()
+	}
+
+	if tryStmt.Catch == nil && tryStmt.Finally == nil {
+		p.errorf(tryStmt.Token, "try without catch or finally")
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return tryStmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	exprStmt := &ast.ExpressionStatement{
 		Token: p.currToken,
@@ -190,66 +441,426 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	return exprStmt
 }
 
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	whileStmt := &ast.WhileStatement{Token: p.currToken}
+
+	p.expectPeek(token.LPAREN)
+	p.nextToken()
+
+	whileStmt.Condition = p.parseExpression(LOWEST)
+
+	p.expectPeek(token.RPAREN)
+	p.expectPeek(token.LBRACE)
+
+	whileStmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return whileStmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	forStmt := &ast.ForStatement{Token: p.currToken}
+
+	p.expectPeek(token.LPAREN)
+	p.expectPeek(token.IDENT)
+
+	forStmt.Variable = &ast.Identifier{
+		Token: p.currToken,
+		Value: p.currToken.Literal,
+	}
+
+	p.expectPeek(token.IN)
+	p.nextToken()
+
+	forStmt.Iterable = p.parseExpression(LOWEST)
+
+	p.expectPeek(token.RPAREN)
+	p.expectPeek(token.LBRACE)
+
+	forStmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return forStmt
+}
+
+// parseImportStatement parses import "path" as name or
+// import {a, b as c} from "path".
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	importStmt := &ast.ImportStatement{Token: p.currToken}
+
+	if p.blockDepth != 0 {
+		p.errorf(p.currToken, "import is only allowed at the top level")
+	}
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		importStmt.Names = p.parseImportSpecs()
+		p.expectPeekWord("from")
+	}
+
+	p.expectPeek(token.STRING)
+	importStmt.Path = &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
+
+	if importStmt.Names == nil {
+		p.expectPeekWord("as")
+		p.expectPeek(token.IDENT)
+		importStmt.Alias = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return importStmt
+}
+
+// parseImportSpecs parses the list of names of an import statement,
+// starting at the '{' in currToken.
+func (p *Parser) parseImportSpecs() []ast.ImportSpec {
+	specs := []ast.ImportSpec{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.expectPeek(token.IDENT)
+		spec := ast.ImportSpec{
+			Name: &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal},
+		}
+
+		if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "as" {
+			p.nextToken()
+			p.expectPeek(token.IDENT)
+			spec.Alias = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		}
+		specs = append(specs, spec)
+
+		if !p.peekTokenIs(token.RBRACE) {
+			p.expectPeek(token.COMMA)
+		}
+	}
+	p.nextToken()
+
+	return specs
+}
+
+// parseExportStatement parses export followed by a let statement or a
+// function declaration.
+func (p *Parser) parseExportStatement() *ast.ExportStatement {
+	exportStmt := &ast.ExportStatement{Token: p.currToken}
+
+	if p.blockDepth != 0 {
+		p.errorf(p.currToken, "export is only allowed at the top level")
+	}
+
+	p.nextToken()
+
+	switch {
+	case p.curTokenIs(token.LET):
+		exportStmt.Statement = p.parseLetStatement()
+	case p.curTokenIs(token.FUNCTION) && p.peekTokenIs(token.IDENT):
+		exportStmt.Statement = p.parseFunctionStatement()
+	default:
+		p.errors = append(p.errors, &Error{
+			Pos:      p.currToken.Pos,
+			Token:    p.currToken,
+			Expected: []token.TokenType{token.LET, token.FUNCTION},
+			Msg:      fmt.Sprintf("expected a let statement or function declaration after export, got = '%s'", p.currToken.Type),
+		})
+		panic(bailout{})
+	}
+
+	return exportStmt
+}
+
+// parseLoopBody parses the block statement starting at currToken as the
+// body of a loop, in which break and continue are allowed.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth += 1
+	defer func() { p.loopDepth -= 1 }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	if p.loopDepth == 0 {
+		p.errorf(p.currToken, "break is not in a loop")
+	}
+
+	breakStmt := &ast.BreakStatement{Token: p.currToken}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return breakStmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	if p.loopDepth == 0 {
+		p.errorf(p.currToken, "continue is not in a loop")
+	}
+
+	continueStmt := &ast.ContinueStatement{Token: p.currToken}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return continueStmt
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.currToken}
 	block.Statements = []ast.Statement{}
 
+	outerDepth := p.blockDepth
+	p.blockDepth = p.braces
+	defer func() { p.blockDepth = outerDepth }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt, synced := p.parseStatementSync()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
-		p.nextToken()
+		if !synced {
+			p.nextToken()
+		}
 	}
+	block.Rbrace = p.currToken
 
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.currToken}
+	p.parseFunction(lit)
+	return lit
+}
 
-	if !p.expectPeek(token.LPAREN) {
-		return nil
-	}
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.currToken}
 
-	lit.Parameters = p.parseFunctionParameters()
+	p.expectPeek(token.LPAREN)
 
-	if !p.expectPeek(token.LBRACE) {
-		return nil
+	lit.Parameters = []*ast.Identifier{}
+	for !p.peekTokenIs(token.RPAREN) {
+		p.expectPeek(token.IDENT)
+		lit.Parameters = append(lit.Parameters, &ast.Identifier{
+			Token: p.currToken,
+			Value: p.currToken.Literal,
+		})
+
+		if !p.peekTokenIs(token.RPAREN) {
+			p.expectPeek(token.COMMA)
+		}
 	}
+	p.nextToken()
+
+	p.expectPeek(token.LBRACE)
+
+	// like functions, macros can't break out of loops around them
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outerLoopDepth }()
 
 	lit.Body = p.parseBlockStatement()
 
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	fnStmt := &ast.FunctionStatement{Token: p.currToken}
+
+	p.nextToken()
+	fnStmt.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+
+	fnStmt.Function = &ast.FunctionLiteral{
+		Token: fnStmt.Token,
+		Name:  fnStmt.Name.Value,
+	}
+	p.parseFunction(fnStmt.Function)
 
-	if p.peekTokenIs(token.RPAREN) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
-		return identifiers
 	}
 
-	p.nextToken()
+	return fnStmt
+}
 
-	ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-	identifiers = append(identifiers, ident)
+// parseFunction parses the parameter list and body of lit, starting with
+// the '(' in peekToken.
+func (p *Parser) parseFunction(lit *ast.FunctionLiteral) {
+	// break and continue can't leave the function
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outerLoopDepth }()
 
-	for p.peekTokenIs(token.COMMA) {
+	p.expectPeek(token.LPAREN)
+
+	lit.Parameters, lit.Rest = p.parseFunctionParameters()
+
+	p.expectPeek(token.LBRACE)
+
+	lit.Body = p.parseBlockStatement()
+}
+
+// parseFunctionParameters parses a parameter list like (a, b = 1, ...c)
+// and returns the parameters and the rest parameter, if there is one.
+func (p *Parser) parseFunctionParameters() ([]ast.Pattern, *ast.Identifier) {
+	parameters := []ast.Pattern{}
+	var rest *ast.Identifier
+
+	for !p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			// the rest parameter has to be the last one, which the
+			// check for ')' below enforces
+			p.expectPeek(token.IDENT)
+			rest = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			break
+		}
+
+		parameters = append(parameters, p.parseParameter(parameters))
+
+		if !p.peekTokenIs(token.RPAREN) {
+			p.expectPeek(token.COMMA)
+		}
+	}
+
+	p.expectPeek(token.RPAREN)
+
+	return parameters, rest
+}
+
+// parseParameter parses the parameter starting at currToken, which
+// follows the parameters in previous.
+func (p *Parser) parseParameter(previous []ast.Pattern) ast.Pattern {
+	target := p.parsePattern()
+
+	if !p.peekTokenIs(token.ASSIGN) {
+		if len(previous) > 0 {
+			if _, ok := previous[len(previous)-1].(*ast.DefaultPattern); ok {
+				p.errorf(p.currToken, "parameter %s without default value follows parameter with default value", target)
+			}
+		}
+		return target
+	}
+
+	p.nextToken()
+	param := &ast.DefaultPattern{Token: p.currToken, Target: target}
+	p.nextToken()
+	param.Default = p.parseExpression(LOWEST)
+
+	return param
+}
+
+// parsePattern parses the binding pattern starting at currToken: a name,
+// an array pattern like [a, [b, c], ...rest] or a hash pattern like
+// {name, age: years}.
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.currToken.Type {
+	case token.ILLEGAL:
+		// already reported by the lexer
+		panic(bailout{})
+	case token.IDENT:
+		return &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE, token.MINUS:
+		if !p.literalPatterns {
+			p.patternError()
+			panic(bailout{})
+		}
+		if p.curTokenIs(token.MINUS) && !p.peekTokenIs(token.INT) && !p.peekTokenIs(token.FLOAT) {
+			p.errorf(p.peekToken, "expected a number after '-' in pattern, got = '%s'", p.peekToken.Type)
+			panic(bailout{})
+		}
+		return &ast.LiteralPattern{Value: p.prefixParseFns[p.currToken.Type]()}
+	default:
+		p.patternError()
+		panic(bailout{})
+	}
+}
+
+// patternError records that currToken can't start a pattern.
+func (p *Parser) patternError() {
+	p.errors = append(p.errors, &Error{
+		Pos:      p.currToken.Pos,
+		Token:    p.currToken,
+		Expected: []token.TokenType{token.IDENT, token.LBRACKET, token.LBRACE},
+		Msg:      fmt.Sprintf("expected a name or a destructuring pattern, got = '%s'", p.currToken.Type),
+	})
+}
+
+func (p *Parser) parseArrayPattern() *ast.ArrayPattern {
+	pattern := &ast.ArrayPattern{
+		Token:    p.currToken,
+		Elements: []ast.Pattern{},
+	}
+
+	for !p.peekTokenIs(token.RBRACKET) {
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-		identifiers = append(identifiers, ident)
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			// the rest element has to be the last one, which the
+			// check for ']' below enforces
+			p.expectPeek(token.IDENT)
+			pattern.Rest = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			break
+		}
+
+		pattern.Elements = append(pattern.Elements, p.parsePattern())
+
+		if !p.peekTokenIs(token.RBRACKET) {
+			p.expectPeek(token.COMMA)
+		}
 	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	p.expectPeek(token.RBRACKET)
+	pattern.Rbracket = p.currToken
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() *ast.HashPattern {
+	pattern := &ast.HashPattern{
+		Token: p.currToken,
+		Pairs: []ast.HashPatternPair{},
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.expectPeek(token.IDENT)
+		pair := ast.HashPatternPair{
+			Key: &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal},
+		}
+
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			pair.Value = p.parsePattern()
+		} else {
+			pair.Value = pair.Key
+		}
+		pattern.Pairs = append(pattern.Pairs, pair)
+
+		if !p.peekTokenIs(token.RBRACE) {
+			p.expectPeek(token.COMMA)
+		}
 	}
 
-	return identifiers
+	p.expectPeek(token.RBRACE)
+	pattern.Rbrace = p.currToken
+
+	return pattern
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
@@ -269,9 +880,7 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 		list = append(list, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(end) {
-		return nil
-	}
+	p.expectPeek(end)
 	return list
 }
 
@@ -279,7 +888,6 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.currToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.currToken.Type)
-		return nil
 	}
 	leftExpr := prefix()
 
@@ -312,16 +920,91 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 		Token: p.currToken,
 	}
 
+	if msg := checkIntegerLiteral(p.currToken.Literal); msg != "" {
+		p.errorf(p.currToken, "%s", msg)
+		return intLit
+	}
+
 	value, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse integer literal '%s'", p.currToken.Literal)
-		p.errors = append(p.errors, msg)
+		if errors.Is(err, strconv.ErrRange) {
+			msg = fmt.Sprintf("integer literal '%s' overflows int64", p.currToken.Literal)
+		}
+		p.errorf(p.currToken, "%s", msg)
 	}
 
 	intLit.Value = value
 	return intLit
 }
 
+// checkIntegerLiteral returns a description of what is wrong with the
+// integer literal lit, or "" if it is well-formed.
+func checkIntegerLiteral(lit string) string {
+	base, name, digits := 10, "decimal", lit
+	if len(lit) >= 2 && lit[0] == '0' {
+		switch lit[1] {
+		case 'x', 'X':
+			base, name, digits = 16, "hexadecimal", lit[2:]
+		case 'o', 'O':
+			base, name, digits = 8, "octal", lit[2:]
+		case 'b', 'B':
+			base, name, digits = 2, "binary", lit[2:]
+		default:
+			return fmt.Sprintf("invalid integer literal '%s': leading zeros are not allowed, use 0o for octal", lit)
+		}
+	}
+
+	if strings.Trim(digits, "_") == "" {
+		return fmt.Sprintf("invalid integer literal '%s': %s literal has no digits", lit, name)
+	}
+
+	for i, char := range digits {
+		if char == '_' {
+			// as in Go, an underscore may also directly follow the prefix
+			prevOk := i > 0 && digits[i-1] != '_' || i == 0 && base != 10
+			nextOk := i+1 < len(digits) && digits[i+1] != '_'
+			if !prevOk || !nextOk {
+				return fmt.Sprintf("invalid integer literal '%s': '_' must separate successive digits", lit)
+			}
+			continue
+		}
+		if digitValue(char) >= base {
+			return fmt.Sprintf("invalid integer literal '%s': invalid digit '%c' in %s literal", lit, char, name)
+		}
+	}
+
+	return ""
+}
+
+// digitValue returns the value of char as a digit in bases up to 36, or
+// 36 if char isn't a digit at all.
+func digitValue(char rune) int {
+	switch {
+	case '0' <= char && char <= '9':
+		return int(char - '0')
+	case 'a' <= char && char <= 'z':
+		return int(char-'a') + 10
+	case 'A' <= char && char <= 'Z':
+		return int(char-'A') + 10
+	}
+	return 36
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	floatLit := &ast.FloatLiteral{
+		Token: p.currToken,
+	}
+
+	value, err := strconv.ParseFloat(p.currToken.Literal, 64)
+	if err != nil && !p.lexerReported(p.currToken) {
+		p.errorf(p.currToken, "could not parse float literal '%s'", p.currToken.Literal)
+	}
+
+	floatLit.Value = value
+	return floatLit
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{
 		Token: p.currToken,
@@ -329,6 +1012,52 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	}
 }
 
+func (p *Parser) parseTemplateLiteral() ast.Expression {
+	template := &ast.TemplateLiteral{Token: p.currToken}
+	template.Parts = p.appendTemplateText(template.Parts)
+
+	for {
+		if p.peekTokenIs(token.TEMPLATE_MIDDLE) || p.peekTokenIs(token.TEMPLATE_TAIL) {
+			p.errorf(p.peekToken, "empty interpolation in string")
+		} else {
+			p.nextToken()
+			template.Parts = append(template.Parts, p.parseExpression(LOWEST))
+		}
+
+		if !p.peekTokenIs(token.TEMPLATE_MIDDLE) && !p.peekTokenIs(token.TEMPLATE_TAIL) {
+			if p.peekTokenIs(token.ILLEGAL) {
+				panic(bailout{})
+			}
+			p.errors = append(p.errors, &Error{
+				Pos:      p.peekToken.Pos,
+				Token:    p.peekToken,
+				Expected: []token.TokenType{token.TEMPLATE_MIDDLE, token.TEMPLATE_TAIL},
+				Msg:      fmt.Sprintf("expected '}' to close interpolation, got = '%s'", p.peekToken.Type),
+			})
+			panic(bailout{})
+		}
+		p.nextToken()
+		template.Parts = p.appendTemplateText(template.Parts)
+
+		if p.curTokenIs(token.TEMPLATE_TAIL) {
+			template.Tail = p.currToken
+			return template
+		}
+	}
+}
+
+// appendTemplateText appends the text of the current template token to
+// parts, unless it is empty.
+func (p *Parser) appendTemplateText(parts []ast.Expression) []ast.Expression {
+	if p.currToken.Literal == "" {
+		return parts
+	}
+	return append(parts, &ast.StringLiteral{
+		Token: p.currToken,
+		Value: p.currToken.Literal,
+	})
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	prefixExpr := &ast.PrefixExpression{
 		Token:    p.currToken,
@@ -350,12 +1079,60 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.currPrecedence()
+	if rightAssociative[p.currToken.Type] {
+		// parsing the right operand with a lower precedence lets it
+		// absorb further operators of the same precedence
+		precedence -= 1
+	}
 	p.nextToken()
 	infixExpr.Right = p.parseExpression(precedence)
 
 	return infixExpr
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	assignExpr := &ast.AssignExpression{
+		Token:  p.currToken,
+		Target: target,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.errorf(p.currToken, "cannot assign to %s", target)
+	}
+
+	// right-associative: a = b = c is a = (b = c)
+	p.nextToken()
+	assignExpr.Value = p.parseExpression(ASSIGN - 1)
+
+	return assignExpr
+}
+
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	pipeExpr := &ast.PipeExpression{Token: p.currToken, Left: left}
+
+	precedence := p.currPrecedence()
+	p.nextToken()
+	pipeExpr.Right = p.parseExpression(precedence)
+
+	return pipeExpr
+}
+
+func (p *Parser) parseLogicalExpression(left ast.Expression) ast.Expression {
+	logicalExpr := &ast.LogicalExpression{
+		Token:    p.currToken,
+		Operator: p.currToken.Literal,
+		Left:     left,
+	}
+
+	precedence := p.currPrecedence()
+	p.nextToken()
+	logicalExpr.Right = p.parseExpression(precedence)
+
+	return logicalExpr
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{
 		Token: p.currToken,
@@ -368,9 +1145,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 
 	exp := p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
-	}
+	p.expectPeek(token.RPAREN)
 
 	return exp
 }
@@ -378,28 +1153,20 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 func (p *Parser) parseIfExpression() ast.Expression {
 	ifExpr := &ast.IfExpression{Token: p.currToken}
 
-	if !p.expectPeek(token.LPAREN) {
-		return nil
-	}
+	p.expectPeek(token.LPAREN)
 	p.nextToken()
 
 	ifExpr.Condition = p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
-	}
-	if !p.expectPeek(token.LBRACE) {
-		return nil
-	}
+	p.expectPeek(token.RPAREN)
+	p.expectPeek(token.LBRACE)
 
 	ifExpr.Consequence = p.parseBlockStatement()
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 
-		if !p.expectPeek(token.LBRACE) {
-			return nil
-		}
+		p.expectPeek(token.LBRACE)
 
 		ifExpr.Alternative = p.parseBlockStatement()
 	}
@@ -407,9 +1174,77 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return ifExpr
 }
 
+func (p *Parser) parseMatchExpression() ast.Expression {
+	matchExpr := &ast.MatchExpression{Token: p.currToken}
+
+	p.expectPeek(token.LPAREN)
+	p.nextToken()
+
+	matchExpr.Subject = p.parseExpression(LOWEST)
+
+	p.expectPeek(token.RPAREN)
+	p.expectPeek(token.LBRACE)
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		matchExpr.Arms = append(matchExpr.Arms, arm)
+
+		// the comma after a block body is optional
+		_, isBlock := arm.Body.(*ast.BlockStatement)
+		if isBlock && p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !isBlock && !p.peekTokenIs(token.RBRACE) {
+			p.expectPeek(token.COMMA)
+		}
+	}
+
+	p.expectPeek(token.RBRACE)
+	matchExpr.Rbrace = p.currToken
+
+	return matchExpr
+}
+
+// parseMatchArm parses a pattern [if guard] => body arm of a match
+// expression, starting at currToken.
+func (p *Parser) parseMatchArm() ast.MatchArm {
+	arm := ast.MatchArm{}
+
+	arm.Pattern = p.parseMatchPattern()
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	p.expectPeek(token.ARROW)
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		arm.Body = p.parseBlockStatement()
+	} else {
+		p.nextToken()
+		arm.Body = p.parseExpression(LOWEST)
+	}
+
+	return arm
+}
+
+// parseMatchPattern parses the pattern starting at currToken, allowing
+// literals in it.
+func (p *Parser) parseMatchPattern() ast.Pattern {
+	outerLiteralPatterns := p.literalPatterns
+	p.literalPatterns = true
+	defer func() { p.literalPatterns = outerLiteralPatterns }()
+
+	return p.parsePattern()
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	callExpr := &ast.CallExpression{Token: p.currToken, Function: function}
 	callExpr.Arguments = p.parseExpressionList(token.RPAREN)
+	callExpr.Rparen = p.currToken
 	return callExpr
 }
 
@@ -419,6 +1254,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	}
 
 	arrayLit.Elements = p.parseExpressionList(token.RBRACKET)
+	arrayLit.Rbracket = p.currToken
 
 	return arrayLit
 }
@@ -426,7 +1262,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{
 		Token: p.currToken,
-		Pairs: make(map[ast.Expression]ast.Expression),
+		Pairs: []ast.HashPair{},
 	}
 
 	for !p.peekTokenIs(token.RBRACE) {
@@ -434,38 +1270,73 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		key := p.parseExpression(LOWEST)
 
-		if !p.expectPeek(token.COLON) {
-			return nil
-		}
+		p.expectPeek(token.COLON)
 		p.nextToken()
 
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
-		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+		if !p.peekTokenIs(token.RBRACE) {
+			p.expectPeek(token.COMMA)
 		}
 	}
 
-	if !p.expectPeek(token.RBRACE) {
-		return nil
-	}
+	p.expectPeek(token.RBRACE)
+	hash.Rbrace = p.currToken
 
 	return hash
 }
 
+// parseIndexExpression parses an index expression left[index] or a slice
+// expression left[low:high], in which both bounds are optional.
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	indexExpr := &ast.IndexExpression{Token: p.currToken, Left: left}
+	lbracket := p.currToken
 
-	p.nextToken()
-	indexExpr.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
 
-	if !p.expectPeek(token.RBRACKET) {
-		return nil
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(lbracket, left, index)
 	}
 
-	return indexExpr
+	p.expectPeek(token.RBRACKET)
+
+	return &ast.IndexExpression{
+		Token:    lbracket,
+		Left:     left,
+		Index:    index,
+		Rbracket: p.currToken,
+	}
+}
+
+// parseSliceExpression parses the rest of a slice expression following the
+// ':' in currToken.
+func (p *Parser) parseSliceExpression(lbracket token.Token, left, low ast.Expression) ast.Expression {
+	sliceExpr := &ast.SliceExpression{Token: lbracket, Left: left, Low: low}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		sliceExpr.High = p.parseExpression(LOWEST)
+	}
+
+	p.expectPeek(token.RBRACKET)
+	sliceExpr.Rbracket = p.currToken
+
+	return sliceExpr
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	memberExpr := &ast.MemberExpression{Token: p.currToken, Object: object}
+
+	p.expectPeek(token.IDENT)
+	memberExpr.Property = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+
+	return memberExpr
 }
 
 //////////////////////////////
@@ -479,29 +1350,82 @@ func (p *Parser) peekTokenIs(targetType token.TokenType) bool {
 	return p.peekToken.Type == targetType
 }
 
-func (p *Parser) expectPeek(targetType token.TokenType) bool {
+// expectPeek advances to peekToken if it has type targetType. Otherwise,
+// it reports an error and abandons the current statement.
+func (p *Parser) expectPeek(targetType token.TokenType) {
 	if p.peekTokenIs(targetType) {
 		p.nextToken()
-		return true
+		return
 	}
 	p.peekError(targetType)
-	return false
 }
 
+// expectPeekWord advances to peekToken if it is the identifier word, one
+// of the words like "as" that are only keywords in certain places.
+// Otherwise, it reports an error and abandons the current statement.
+func (p *Parser) expectPeekWord(word string) {
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == word {
+		p.nextToken()
+		return
+	}
+	if p.peekTokenIs(token.ILLEGAL) {
+		panic(bailout{})
+	}
+	p.errors = append(p.errors, &Error{
+		Pos:   p.peekToken.Pos,
+		Token: p.peekToken,
+		Msg: fmt.Sprintf(
+			"expected '%s' , got = '%s'",
+			word,
+			p.peekToken.Literal,
+		),
+	})
+	panic(bailout{})
+}
+
+// errorf records an error at tok. Errors at ILLEGAL tokens are left out,
+// as the lexer has already reported them.
+func (p *Parser) errorf(tok token.Token, format string, a ...interface{}) {
+	if tok.Type == token.ILLEGAL {
+		return
+	}
+	p.errors = append(p.errors, &Error{
+		Pos:   tok.Pos,
+		Token: tok,
+		Msg:   fmt.Sprintf(format, a...),
+	})
+}
+
+// peekError reports that peekToken doesn't have type expectedType and
+// abandons the current statement.
 func (p *Parser) peekError(expectedType token.TokenType) {
-	p.errors = append(
-		p.errors,
-		fmt.Sprintf(
+	if p.peekTokenIs(token.ILLEGAL) {
+		panic(bailout{})
+	}
+	p.errors = append(p.errors, &Error{
+		Pos:      p.peekToken.Pos,
+		Token:    p.peekToken,
+		Expected: []token.TokenType{expectedType},
+		Msg: fmt.Sprintf(
 			"expected token = '%s' , got = '%s'",
 			expectedType,
 			p.peekToken.Type,
 		),
-	)
+	})
+	panic(bailout{})
+}
+
+// parseIllegal abandons the statement at an ILLEGAL token. The lexer has
+// already reported the token, so no error is recorded.
+func (p *Parser) parseIllegal() ast.Expression {
+	panic(bailout{})
 }
 
+// noPrefixParseFnError reports that no expression can start with t and
+// abandons the current statement.
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for '%s' found", t)
-	p.errors = append(p.errors, msg)
+	p.errorf(p.currToken, "no prefix parse function for '%s' found", t)
+	panic(bailout{})
 }
 
 func (p *Parser) peekPrecedence() int {
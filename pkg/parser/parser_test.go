@@ -2,10 +2,12 @@ package parser
 
 import (
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/sbrki/monkey/pkg/ast"
 	"github.com/sbrki/monkey/pkg/lexer"
+	"github.com/sbrki/monkey/pkg/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -57,14 +59,19 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 		return false
 	}
 
-	if letStmt.Name.Value != name {
+	ident, ok := letStmt.Name.(*ast.Identifier)
+	if !ok {
+		t.Errorf("letStmt.Name is not *ast.Identifier. got = %T", letStmt.Name)
+		return false
+	}
+	if ident.Value != name {
 		t.Errorf("letStmt.Name.Value = %s, expected = %s",
-			letStmt.Name.Value, name)
+			ident.Value, name)
 		return false
 	}
-	if letStmt.Name.TokenLiteral() != name {
+	if ident.TokenLiteral() != name {
 		t.Errorf("letStmt.Name.TokenLiteral() = %s, expected = %s",
-			letStmt.Name.TokenLiteral(), name)
+			ident.TokenLiteral(), name)
 	}
 	return true
 }
@@ -169,6 +176,43 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E3;", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("len(program.Statements) = %d, expected = 1",
+				len(program.Statements))
+		}
+
+		exprStmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("Could not downcast ast.Statement to ast.ExpressionStatement. got = %q", program.Statements[0])
+		}
+
+		literal, ok := exprStmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("Could not downcast ast.Expression to ast.FloatLiteral. got = %T", exprStmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value = %g, expected = %g", literal.Value, tt.expected)
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"foo bar"`
 
@@ -513,6 +557,86 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1,2][1])",
 			"add((a*(b[2])),(b[1]),(2*([1,2][1])))",
 		},
+		{
+			"xs |> map(f) |> filter(g)",
+			"((xs|>map(f))|>filter(g))",
+		},
+		{
+			"a || b |> f",
+			"((a||b)|>f)",
+		},
+		{
+			"x = xs |> f >> g",
+			"(x = (xs|>(f>>g)))",
+		},
+		{
+			"xs |> fn(x) { x }",
+			"(xs|>fn(x)x)",
+		},
+		{
+			"-xs[-2:n + 1][0]",
+			"(-((xs[(-2):(n+1)])[0]))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a<=b)==(c>=d))",
+		},
+		{
+			"a + b % c",
+			"(a+(b%c))",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2**(3**2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2**2))",
+		},
+		{
+			"2 ** -1 * 3",
+			"((2**(-1))*3)",
+		},
+		{
+			"a | b ^ c & d",
+			"(a|(b^(c&d)))",
+		},
+		{
+			"a & b == c",
+			"((a&b)==c)",
+		},
+		{
+			"1 << 2 + 3 < 4 >> 1",
+			"((1<<(2+3))<(4>>1))",
+		},
+		{
+			"~a & b",
+			"((~a)&b)",
+		},
+		{
+			"a || b && c",
+			"(a||(b&&c))",
+		},
+		{
+			"a && b || c && d",
+			"((a&&b)||(c&&d))",
+		},
+		{
+			"a == b && c < d || !e",
+			"(((a==b)&&(c<d))||(!e))",
+		},
+		{
+			"x = a || b",
+			"(x = (a||b))",
+		},
+		{
+			"x = y = z + 1",
+			"(x = (y = (z+1)))",
+		},
+		{
+			"a[i + 1] = h[k] = f(x)",
+			"((a[(i+1)]) = ((h[k]) = f(x)))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -528,6 +652,35 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestLogicalExpression(t *testing.T) {
+	input := "a && b || c;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	exprStmt := program.Statements[0].(*ast.ExpressionStatement)
+	or, ok := exprStmt.Expression.(*ast.LogicalExpression)
+	if !ok {
+		t.Fatalf("Could not downcast ast.Expression to ast.LogicalExpression. got = %T", exprStmt.Expression)
+	}
+	if or.Operator != "||" {
+		t.Errorf("or.Operator = %q, expected = '||'", or.Operator)
+	}
+	testIdentifier(t, or.Right, "c")
+
+	and, ok := or.Left.(*ast.LogicalExpression)
+	if !ok {
+		t.Fatalf("Could not downcast ast.Expression to ast.LogicalExpression. got = %T", or.Left)
+	}
+	if and.Operator != "&&" {
+		t.Errorf("and.Operator = %q, expected = '&&'", and.Operator)
+	}
+	testIdentifier(t, and.Left, "a")
+	testIdentifier(t, and.Right, "b")
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -702,8 +855,8 @@ func TestFunctionLiteralParsing(t *testing.T) {
 		t.Fatalf("len(functionLiteral.Parameters) = %d, want = 2", len(functionLiteral.Parameters))
 	}
 
-	testLiteralExpression(t, functionLiteral.Parameters[0], "x")
-	testLiteralExpression(t, functionLiteral.Parameters[1], "y")
+	testLiteralExpression(t, functionLiteral.Parameters[0].(*ast.Identifier), "x")
+	testLiteralExpression(t, functionLiteral.Parameters[1].(*ast.Identifier), "y")
 
 	if len(functionLiteral.Body.Statements) != 1 {
 		t.Fatalf("len(functionLiteral.Body.Statements = %d, want = 1", len(functionLiteral.Body.Statements))
@@ -851,17 +1004,34 @@ func TestParsingHashLiteralStringKeys(t *testing.T) {
 		"three": 3,
 	}
 
-	for key, value := range hashLiteral.Pairs {
+	for _, pair := range hashLiteral.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral, got=%T", key)
 		}
 
-		expectedValue := expected[literal.String()]
+		expectedValue := expected[literal.Value]
 		testIntegerLiteral(t, value, expectedValue)
 	}
 }
 
+func TestHashLiteralOrder(t *testing.T) {
+	input := `{"z": 1, "a": 2, 3: "m", true: fn(x) { x }}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := `{"z":1,"a":2,3:"m",true:fn(x)x}`
+	for i := 0; i < 10; i++ {
+		if got := program.String(); got != expected {
+			t.Fatalf("hash literal printed out of order. expected=%s, got=%s", expected, got)
+		}
+	}
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := "{}"
 
@@ -929,16 +1099,17 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hashLiteral.Pairs {
+	for _, pair := range hashLiteral.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral, got=%T", key)
 			continue
 		}
 
-		testFunc, ok := tests[literal.String()]
+		testFunc, ok := tests[literal.Value]
 		if !ok {
-			t.Errorf("No test function for key %q found", literal.String())
+			t.Errorf("No test function for key %q found", literal.Value)
 			continue
 		}
 
@@ -959,3 +1130,1300 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestNodeSpans(t *testing.T) {
+	input := `let f = fn(x) {
+	x * 2
+};
+f(21) + [1, 2][0]`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("len(program.Statements) = %d, expected = 2",
+			len(program.Statements))
+	}
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	exprStmt := program.Statements[1].(*ast.ExpressionStatement)
+	infix := exprStmt.Expression.(*ast.InfixExpression)
+
+	tests := []struct {
+		node          ast.Node
+		expectedStart string
+		expectedEnd   string
+	}{
+		{program, "1:1", "4:18"},
+		{letStmt, "1:1", "3:2"},
+		{letStmt.Value, "1:9", "3:2"},
+		{letStmt.Value.(*ast.FunctionLiteral).Body, "1:15", "3:2"},
+		{exprStmt, "4:1", "4:18"},
+		{infix.Left, "4:1", "4:6"},
+		{infix.Right, "4:9", "4:18"},
+		{infix.Right.(*ast.IndexExpression).Left, "4:9", "4:15"},
+	}
+
+	for i, tt := range tests {
+		if start := tt.node.Pos().String(); start != tt.expectedStart {
+			t.Errorf("tests[%d] (%s) - start wrong. expected=%s, got=%s",
+				i, tt.node, tt.expectedStart, start)
+		}
+		if end := tt.node.End().String(); end != tt.expectedEnd {
+			t.Errorf("tests[%d] (%s) - end wrong. expected=%s, got=%s",
+				i, tt.node, tt.expectedEnd, end)
+		}
+	}
+}
+
+func TestStringLiteralRoundTrip(t *testing.T) {
+	tests := []string{
+		`"plain"`,
+		`"quote \" and backslash \\"`,
+		`"tab\tnewline\nreturn\r"`,
+		`"\x00\x7f\xff"`,
+		`"unicode é \u{1F600} \u{200B}"`,
+		`"literal \${x} and $y"`,
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		printed := program.String()
+
+		l = lexer.New(printed)
+		p = New(l)
+		reparsed := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		original := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StringLiteral)
+		roundTripped := reparsed.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StringLiteral)
+		if original.Value != roundTripped.Value {
+			t.Errorf("string did not round-trip. input=%s, printed=%s, got=%q, expected=%q",
+				input, printed, roundTripped.Value, original.Value)
+		}
+	}
+}
+
+func TestTemplateLiteralParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"hello ${name}!"`, `"hello ${name}!"`},
+		{`"${a + b * c}"`, `"${(a+(b*c))}"`},
+		{`"n=${len(items)}, \${x}"`, `"n=${len(items)}, \${x}"`},
+		{`"${ {"k": 1}["k"] }"`, `"${({"k":1}["k"])}"`},
+		{`"outer ${"inner ${x}"}"`, `"outer ${"inner ${x}"}"`},
+		{"`raw ${x}\n`", `"raw \${x}\n"`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+		}
+		printed := program.String()
+		if printed != tt.expected {
+			t.Errorf("expected=%s, got=%s", tt.expected, printed)
+		}
+	}
+}
+
+func TestTemplateLiteralParts(t *testing.T) {
+	input := `"a${x}${y}b"`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	template, ok := stmt.Expression.(*ast.TemplateLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.TemplateLiteral. got=%T", stmt.Expression)
+	}
+	if len(template.Parts) != 4 {
+		t.Fatalf("template has wrong number of parts. got=%d", len(template.Parts))
+	}
+	if str, ok := template.Parts[0].(*ast.StringLiteral); !ok || str.Value != "a" {
+		t.Errorf("parts[0] is not \"a\". got=%s", template.Parts[0])
+	}
+	testIdentifier(t, template.Parts[1], "x")
+	testIdentifier(t, template.Parts[2], "y")
+	if str, ok := template.Parts[3].(*ast.StringLiteral); !ok || str.Value != "b" {
+		t.Errorf("parts[3] is not \"b\". got=%s", template.Parts[3])
+	}
+	if template.End().String() != "1:13" {
+		t.Errorf("template end wrong. got=%s", template.End())
+	}
+}
+
+func TestTemplateLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`"a${}b"`, "1:5: empty interpolation in string"},
+		{`"a${x y}b"`, "1:7: expected '}' to close interpolation, got = 'IDENT'"},
+		// an interpolation that isn't closed is reported once, at its ${
+		{`"a${x`, "1:3: unclosed interpolation in string"},
+		{`"a${x"`, "1:3: unclosed interpolation in string"},
+		{`"a${x}b${f(y"`, "1:8: unclosed interpolation in string"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %s: expected error %q, got=%q", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestLexerDiagnosticsAreParserErrors(t *testing.T) {
+	l := lexer.New(`let s = "a\qb";`)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got=%v", errors)
+	}
+	if errors[0].Error() != `1:11: unknown escape sequence: \q` {
+		t.Errorf("wrong error, got=%q", errors[0])
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0x1F", 31},
+		{"0XfF", 255},
+		{"0o17", 15},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0x_dead_beef", 0xdeadbeef},
+		{"0", 0},
+		{"9223372036854775807", 9223372036854775807},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("len(program.Statements) = %d, expected = 1",
+				len(program.Statements))
+		}
+
+		exprStmt := program.Statements[0].(*ast.ExpressionStatement)
+		intLit, ok := exprStmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("Could not downcast ast.Expression to ast.IntegerLiteral. got = %T", exprStmt.Expression)
+		}
+		if intLit.Value != tt.expected {
+			t.Errorf("intLit.Value = %d, expected = %d", intLit.Value, tt.expected)
+		}
+	}
+}
+
+func TestMalformedIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"0x", "invalid integer literal '0x': hexadecimal literal has no digits"},
+		{"0b_", "invalid integer literal '0b_': binary literal has no digits"},
+		{"0b102", "invalid integer literal '0b102': invalid digit '2' in binary literal"},
+		{"0o78", "invalid integer literal '0o78': invalid digit '8' in octal literal"},
+		{"0x1G", "invalid integer literal '0x1G': invalid digit 'G' in hexadecimal literal"},
+		{"1__000", "invalid integer literal '1__000': '_' must separate successive digits"},
+		{"1000_", "invalid integer literal '1000_': '_' must separate successive digits"},
+		{"017", "invalid integer literal '017': leading zeros are not allowed, use 0o for octal"},
+		{"9223372036854775808", "integer literal '9223372036854775808' overflows int64"},
+		{"0xFFFFFFFFFFFFFFFFF", "integer literal '0xFFFFFFFFFFFFFFFFF' overflows int64"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("input %q: expected 1 error, got=%v", tt.input, errors)
+			continue
+		}
+		if errors[0].Msg != tt.expectedError {
+			t.Errorf("input %q: wrong error. expected=%q, got=%q",
+				tt.input, tt.expectedError, errors[0].Msg)
+		}
+		if errors[0].Pos.String() != "1:1" || errors[0].Token.Literal != tt.input {
+			t.Errorf("input %q: wrong error location. got=%s %q",
+				tt.input, errors[0].Pos, errors[0].Token.Literal)
+		}
+	}
+}
+
+func TestParseNextStatementStreaming(t *testing.T) {
+	r, w := io.Pipe()
+	defer r.Close()
+
+	written := make(chan struct{})
+	go func() {
+		io.WriteString(w, "let a = 1; let b = ")
+		close(written)
+	}()
+
+	l := lexer.NewReader(r)
+	p := New(l)
+
+	// the first statement is available although the input isn't complete
+	stmt := p.ParseNextStatement()
+	<-written
+	if stmt == nil || stmt.String() != "let a = 1;" {
+		t.Fatalf("first statement wrong, got=%v", stmt)
+	}
+
+	go func() {
+		io.WriteString(w, "a + 1;")
+		w.Close()
+	}()
+
+	stmt = p.ParseNextStatement()
+	checkParserErrors(t, p)
+	if stmt == nil || stmt.String() != "let b = (a+1);" {
+		t.Fatalf("second statement wrong, got=%v", stmt)
+	}
+	if !p.Done() {
+		t.Errorf("parser not done after the last statement")
+	}
+}
+
+func TestParseNextStatementWithErrors(t *testing.T) {
+	l := lexer.New("let = 5; x;")
+	p := New(l)
+
+	var statements []ast.Statement
+	for !p.Done() {
+		statements = append(statements, p.ParseNextStatement())
+	}
+
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+	if statements[0] != nil {
+		t.Errorf("invalid statement is not nil, got=%T", statements[0])
+	}
+}
+
+func TestLexerDiagnosticsWithoutCascade(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+	}{
+		{
+			"let x = 5 # 3;",
+			[]string{"1:11: illegal character '#'"},
+		},
+		{
+			"add(1, 2 @);",
+			[]string{"1:10: illegal character '@'"},
+		},
+		{
+			"let s = 1;\nlet t = \"abc;\nlet u = 2;",
+			[]string{"2:9: unterminated string"},
+		},
+		{
+			"let x = 1e;",
+			[]string{"1:10: exponent has no digits"},
+		},
+		{
+			"let x = @;",
+			[]string{"1:9: illegal character '@'"},
+		},
+		{
+			"foo(@)",
+			[]string{"1:5: illegal character '@'"},
+		},
+		{
+			"1 + \x01",
+			[]string{"1:5: illegal character '\\x01'"},
+		},
+		{
+			"let \xff = 1;",
+			[]string{"1:5: invalid UTF-8 encoding"},
+		},
+		{
+			"let [a, @] = x;",
+			[]string{"1:9: illegal character '@'"},
+		},
+		{
+			"let a = 1;\x00 let b = 2;",
+			[]string{"1:11: illegal character '\\x00'"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		var errors []string
+		for _, err := range p.Errors() {
+			errors = append(errors, err.Error())
+		}
+		if fmt.Sprint(errors) != fmt.Sprint(tt.expectedErrors) {
+			t.Errorf("input %q: wrong errors. expected=%q, got=%q",
+				tt.input, tt.expectedErrors, errors)
+		}
+	}
+}
+
+func TestIllegalTokenAbandonsStatement(t *testing.T) {
+	l := lexer.New("let x = 5 # 3;\nlet y = 2;")
+	p := New(l)
+	program := p.ParseProgram()
+
+	if program.String() != "let x = 5;let y = 2;" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	input := "let x 5;\nlet = 1;"
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+
+	err := errors[0]
+	if err.Pos.String() != "1:7" {
+		t.Errorf("wrong position. expected=1:7, got=%s", err.Pos)
+	}
+	if err.Token.Type != token.INT || err.Token.Literal != "5" {
+		t.Errorf("wrong token. got=%q %q", err.Token.Type, err.Token.Literal)
+	}
+	if len(err.Expected) != 1 || err.Expected[0] != token.ASSIGN {
+		t.Errorf("wrong expected token types. got=%v", err.Expected)
+	}
+	if err.Error() != "1:7: expected token = '=' , got = 'INT'" {
+		t.Errorf("wrong error string. got=%q", err.Error())
+	}
+	if errors.Err() == nil {
+		t.Errorf("Err() of a non-empty list is nil")
+	}
+	if (ErrorList{}).Err() != nil {
+		t.Errorf("Err() of an empty list is not nil")
+	}
+}
+
+func TestErrorListSortAndRemoveDuplicates(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column}
+	}
+	errors := ErrorList{
+		{Pos: pos(2, 1), Msg: "b"},
+		{Pos: pos(1, 5), Msg: "a"},
+		{Pos: pos(2, 1), Msg: "a"},
+		{Pos: pos(1, 5), Msg: "a"},
+		{Pos: pos(1, 2), Msg: "c"},
+	}
+
+	errors.RemoveDuplicates()
+
+	expected := []string{"1:2: c", "1:5: a", "2:1: a", "2:1: b"}
+	if len(errors) != len(expected) {
+		t.Fatalf("wrong number of errors. expected=%d, got=%d", len(expected), len(errors))
+	}
+	for i, err := range errors {
+		if err.Error() != expected[i] {
+			t.Errorf("errors[%d] wrong. expected=%q, got=%q", i, expected[i], err.Error())
+		}
+	}
+	if errors.Error() != "1:2: c (and 3 more errors)" {
+		t.Errorf("wrong list error string. got=%q", errors.Error())
+	}
+}
+
+func TestErrorExcerpt(t *testing.T) {
+	tests := []struct {
+		src      string
+		pos      token.Position
+		expected string
+	}{
+		{"let x 5;", token.Position{Line: 1, Column: 7}, "let x 5;\n      ^"},
+		{"a\n\tlet é 5;", token.Position{Line: 2, Column: 8}, "\tlet é 5;\n\t      ^"},
+		{"a", token.Position{Line: 3, Column: 1}, ""},
+		{"a", token.Position{}, ""},
+	}
+
+	for _, tt := range tests {
+		err := &Error{Pos: tt.pos, Msg: "oops"}
+		if excerpt := err.Excerpt(tt.src); excerpt != tt.expected {
+			t.Errorf("wrong excerpt for %q at %s. expected=%q, got=%q",
+				tt.src, tt.pos, tt.expected, excerpt)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			"let x 5;\nlet y = 10;\nlet = 1;\nlet z = (1 + ;\nz;",
+			[]string{
+				"1:7: expected token = '=' , got = 'INT'",
+				"3:5: expected a name or a destructuring pattern, got = '='",
+				"4:14: no prefix parse function for ';' found",
+			},
+			[]string{"let y = 10;", "z"},
+		},
+		{
+			// recovery inside a function body keeps the function
+			"let f = fn(x) {\n  let a = ;\n  x + 1\n};\nf(1)",
+			[]string{"2:11: no prefix parse function for ';' found"},
+			[]string{"let f = fn(x)(x+1);", "f(1)"},
+		},
+		{
+			"let f = fn() { x + }; let y = 2;",
+			[]string{"1:20: no prefix parse function for '}' found"},
+			[]string{"let f = fn();", "let y = 2;"},
+		},
+		{
+			// nested blocks are skipped as a whole
+			"let a = foo(1 fn() { let b = 2; }); let c = 3;",
+			[]string{"1:15: expected token = ')' , got = 'FUNCTION'"},
+			[]string{"let c = 3;"},
+		},
+		{
+			"let a = [1, 2\nlet b = 3",
+			[]string{"2:1: expected token = ']' , got = 'LET'"},
+			[]string{"let b = 3;"},
+		},
+		{
+			"} let a = 1; if (a) { return } let b = 2;",
+			[]string{
+				"1:1: no prefix parse function for '}' found",
+				"1:30: no prefix parse function for '}' found",
+			},
+			[]string{"let a = 1;", "ifa ", "let b = 2;"},
+		},
+		{
+			"let x = (1 + 2",
+			[]string{"1:15: expected token = ')' , got = 'EOF'"},
+			[]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		var errors []string
+		for _, err := range p.Errors() {
+			errors = append(errors, err.Error())
+		}
+		if fmt.Sprint(errors) != fmt.Sprint(tt.expectedErrors) {
+			t.Errorf("input %q: wrong errors.\nexpected=%q\ngot=%q",
+				tt.input, tt.expectedErrors, errors)
+		}
+
+		var statements []string
+		for _, stmt := range program.Statements {
+			statements = append(statements, stmt.String())
+		}
+		if fmt.Sprint(statements) != fmt.Sprint(tt.expectedStatements) {
+			t.Errorf("input %q: wrong statements.\nexpected=%q\ngot=%q",
+				tt.input, tt.expectedStatements, statements)
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x }", "while(x<10) x"},
+		{"while (true) { break; continue; }", "whiletrue break;continue;"},
+		{"for (x in [1, 2]) { puts(x) }", "for(x in [1,2]) puts(x)"},
+		{"for (k in h) { for (c in k) { break } }", "for(k in h) for(c in k) break;"},
+		{"while (a) { let f = fn() { 1 }; continue }", "whilea let f = fn()1;continue;"},
+		{"while (x) { x };", "whilex x"},
+		{"for (x in xs) { };", "for(x in xs) "},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (item in items) { item }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ForStatement. got=%T", program.Statements[0])
+	}
+	testIdentifier(t, stmt.Variable, "item")
+	testIdentifier(t, stmt.Iterable, "items")
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body has wrong number of statements. got=%d", len(stmt.Body.Statements))
+	}
+	if stmt.End().String() != "1:29" {
+		t.Errorf("for statement end wrong. got=%s", stmt.End())
+	}
+}
+
+func TestBreakContinueOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+	}{
+		{"break;", []string{"1:1: break is not in a loop"}},
+		{"if (x) { continue }", []string{"1:10: continue is not in a loop"}},
+		{
+			"while (x) { let f = fn() { break; }; }",
+			[]string{"1:28: break is not in a loop"},
+		},
+		{"while (x) { fn() { 1 }; break; }", nil},
+		{"for (x y) { }", []string{"1:8: expected token = 'IN' , got = 'IDENT'"}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		var errors []string
+		for _, err := range p.Errors() {
+			errors = append(errors, err.Error())
+		}
+		if fmt.Sprint(errors) != fmt.Sprint(tt.expectedErrors) {
+			t.Errorf("input %q: wrong errors. expected=%q, got=%q",
+				tt.input, tt.expectedErrors, errors)
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	input := `counter[0] = n = n + 1;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	assign, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.AssignExpression. got=%T", stmt.Expression)
+	}
+	index, ok := assign.Target.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("target not *ast.IndexExpression. got=%T", assign.Target)
+	}
+	testIdentifier(t, index.Left, "counter")
+	testIntegerLiteral(t, index.Index, 0)
+
+	inner, ok := assign.Value.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("value not *ast.AssignExpression. got=%T", assign.Value)
+	}
+	testIdentifier(t, inner.Target, "n")
+	testInfixExpression(t, inner.Value, "n", "+", 1)
+
+	if assign.Pos().String() != "1:1" || assign.End().String() != "1:23" {
+		t.Errorf("wrong span. got=%s-%s", assign.Pos(), assign.End())
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"1 = 2", "1:3: cannot assign to 1"},
+		{"a + b = c", "1:7: cannot assign to (a+b)"},
+		{"f(x) = 1", "1:6: cannot assign to f(x)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestDestructuringPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = arr;", "let [a,b] = arr;"},
+		{"let [a, b, ...tail] = arr;", "let [a,b,...tail] = arr;"},
+		{"let [...all] = arr;", "let [...all] = arr;"},
+		{"let [] = arr;", "let [] = arr;"},
+		{"let [a, [b, c],] = arr;", "let [a,[b,c]] = arr;"},
+		{"let {name, age: years} = person;", "let {name,age:years} = person;"},
+		{"let {pos: [x, y], meta: {id}} = p;", "let {pos:[x,y],meta:{id}} = p;"},
+		{"let [{a}, {b: c}] = arr;", "let [{a},{b:c}] = arr;"},
+		{"fn([a, b], {c}, d) { a }", "fn([a,b],{c},d)a"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestArrayPatternParsing(t *testing.T) {
+	input := `let [first, {id}, ...others] = xs;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	pattern, ok := stmt.Name.(*ast.ArrayPattern)
+	if !ok {
+		t.Fatalf("stmt.Name is not *ast.ArrayPattern. got=%T", stmt.Name)
+	}
+	if len(pattern.Elements) != 2 {
+		t.Fatalf("pattern has wrong number of elements. got=%d", len(pattern.Elements))
+	}
+	testIdentifier(t, pattern.Elements[0].(*ast.Identifier), "first")
+
+	hashPattern, ok := pattern.Elements[1].(*ast.HashPattern)
+	if !ok {
+		t.Fatalf("pattern.Elements[1] is not *ast.HashPattern. got=%T", pattern.Elements[1])
+	}
+	if len(hashPattern.Pairs) != 1 || hashPattern.Pairs[0].Key.Value != "id" {
+		t.Errorf("wrong hash pattern. got=%s", hashPattern)
+	}
+	testIdentifier(t, hashPattern.Pairs[0].Value.(*ast.Identifier), "id")
+
+	if pattern.Rest == nil {
+		t.Fatalf("pattern.Rest is nil")
+	}
+	testIdentifier(t, pattern.Rest, "others")
+
+	if pattern.Pos().String() != "1:5" || pattern.End().String() != "1:29" {
+		t.Errorf("wrong pattern span. got=%s-%s", pattern.Pos(), pattern.End())
+	}
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let 5 = x;", "1:5: expected a name or a destructuring pattern, got = 'INT'"},
+		{"let [a, ...b, c] = x;", "1:13: expected token = ']' , got = ','"},
+		{"let [a, ...] = x;", "1:12: expected token = 'IDENT' , got = ']'"},
+		{"let {1: a} = x;", "1:6: expected token = 'IDENT' , got = 'INT'"},
+		{"let {a: 1} = x;", "1:9: expected a name or a destructuring pattern, got = 'INT'"},
+		{"fn(a, 1) { a }", "1:7: expected a name or a destructuring pattern, got = 'INT'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedRest  string
+		expectedPrint string
+	}{
+		{"fn(x, y = 10) { x }", "", "fn(x,y = 10)x"},
+		{"fn(first, ...others) { first }", "others", "fn(first,...others)first"},
+		{"fn(...args) { args }", "args", "fn(...args)args"},
+		{"fn(a, b = a * 2, ...c) { c }", "c", "fn(a,b = (a*2),...c)c"},
+		{"fn([a, b] = [1, 2]) { a }", "", "fn([a,b] = [1,2])a"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("exp is not *ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+		if tt.expectedRest == "" && function.Rest != nil {
+			t.Errorf("function.Rest is not nil. got=%s", function.Rest)
+		}
+		if tt.expectedRest != "" {
+			if function.Rest == nil {
+				t.Fatalf("function.Rest is nil")
+			}
+			testIdentifier(t, function.Rest, tt.expectedRest)
+		}
+		if program.String() != tt.expectedPrint {
+			t.Errorf("expected=%q, got=%q", tt.expectedPrint, program.String())
+		}
+	}
+}
+
+func TestDefaultParameterParsing(t *testing.T) {
+	input := `fn(x, y = 10) { x + y }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.Parameters) != 2 {
+		t.Fatalf("function has wrong number of parameters. got=%d", len(function.Parameters))
+	}
+	def, ok := function.Parameters[1].(*ast.DefaultPattern)
+	if !ok {
+		t.Fatalf("parameter is not *ast.DefaultPattern. got=%T", function.Parameters[1])
+	}
+	testIdentifier(t, def.Target.(*ast.Identifier), "y")
+	testIntegerLiteral(t, def.Default, 10)
+	if def.Pos().String() != "1:7" || def.End().String() != "1:13" {
+		t.Errorf("wrong parameter span. got=%s-%s", def.Pos(), def.End())
+	}
+}
+
+func TestInvalidParameterLists(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"fn(...a, b) { a }", "1:8: expected token = ')' , got = ','"},
+		{"fn(a = 1, b) { a }", "1:11: parameter b without default value follows parameter with default value"},
+		{"fn(a, ...) { a }", "1:10: expected token = 'IDENT' , got = ')'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	input := `fn add(x, y) { x + y }; fn() { 1 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program has wrong number of statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.FunctionStatement. got=%T", program.Statements[0])
+	}
+	testIdentifier(t, stmt.Name, "add")
+	if stmt.Function.Name != "add" {
+		t.Errorf("function name wrong. got=%q", stmt.Function.Name)
+	}
+	if len(stmt.Function.Parameters) != 2 {
+		t.Fatalf("function has wrong number of parameters. got=%d", len(stmt.Function.Parameters))
+	}
+	if stmt.String() != "fn add(x,y)(x+y)" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+	if stmt.Pos().String() != "1:1" || stmt.End().String() != "1:23" {
+		t.Errorf("wrong span. got=%s-%s", stmt.Pos(), stmt.End())
+	}
+
+	// fn without a name is still a function literal
+	exprStmt, ok := program.Statements[1].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not *ast.ExpressionStatement. got=%T", program.Statements[1])
+	}
+	if _, ok := exprStmt.Expression.(*ast.FunctionLiteral); !ok {
+		t.Fatalf("exp is not *ast.FunctionLiteral. got=%T", exprStmt.Expression)
+	}
+}
+
+func TestFunctionNameFromLet(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedName string
+	}{
+		{"let square = fn(x) { x * x };", "square"},
+		{"let [a] = fn(x) { x };", ""},
+		{"let f = g;", ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.LetStatement)
+		fn, ok := stmt.Value.(*ast.FunctionLiteral)
+		if !ok {
+			if tt.expectedName != "" {
+				t.Errorf("value is not *ast.FunctionLiteral. got=%T", stmt.Value)
+			}
+			continue
+		}
+		if fn.Name != tt.expectedName {
+			t.Errorf("function name wrong. expected=%q, got=%q", tt.expectedName, fn.Name)
+		}
+	}
+
+	// the name doesn't change how the literal prints
+	l := lexer.New("let square = fn(x) { x * x };")
+	p := New(l)
+	program := p.ParseProgram()
+	if program.String() != "let square = fn(x)(x*x);" {
+		t.Errorf("let statement printed wrong. got=%q", program.String())
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+
+	if program.String() != "macro(x,y)(x+y)" {
+		t.Errorf("macro literal printed wrong. got=%q", program.String())
+	}
+}
+
+func TestImportExportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/strings.mk" as s;`, `import "lib/strings.mk" as s;`},
+		{`import {upper, lower as lo} from "lib/strings.mk"`, `import {upper,lower as lo} from "lib/strings.mk";`},
+		{`import {} from "empty.mk";`, `import {} from "empty.mk";`},
+		{`export let answer = 42;`, `export let answer = 42;`},
+		{`export let [a, b] = pair;`, `export let [a,b] = pair;`},
+		{`export fn twice(x) { x * 2 }`, `export fn twice(x)(x*2)`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestImportSpecs(t *testing.T) {
+	l := lexer.New(`import {upper, lower as lo} from "lib/strings.mk";`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("statement is not *ast.ImportStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Path.Value != "lib/strings.mk" {
+		t.Errorf("path wrong. got=%q", stmt.Path.Value)
+	}
+	if stmt.Alias != nil {
+		t.Errorf("alias is not nil. got=%s", stmt.Alias)
+	}
+
+	expected := []struct{ name, local string }{
+		{"upper", "upper"},
+		{"lower", "lo"},
+	}
+	if len(stmt.Names) != len(expected) {
+		t.Fatalf("wrong number of names. got=%d", len(stmt.Names))
+	}
+	for i, spec := range stmt.Names {
+		if spec.Name.Value != expected[i].name || spec.Local().Value != expected[i].local {
+			t.Errorf("names[%d] wrong. expected %s as %s, got %s as %s",
+				i, expected[i].name, expected[i].local, spec.Name.Value, spec.Local().Value)
+		}
+	}
+}
+
+func TestInvalidImportExport(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`import "a.mk";`, "1:14: expected 'as' , got = ';'"},
+		{`import {a} "a.mk";`, "1:12: expected 'from' , got = 'a.mk'"},
+		{`import {a b} from "a.mk";`, "1:11: expected token = ',' , got = 'IDENT'"},
+		{`import a as b;`, "1:8: expected token = 'STRING' , got = 'IDENT'"},
+		{`if (true) { import "a.mk" as a; }`, "1:13: import is only allowed at the top level"},
+		{`export 5;`, "1:8: expected a let statement or function declaration after export, got = 'INT'"},
+		{`fn f() { export let x = 1; }`, "1:10: export is only allowed at the top level"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestMemberExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"s.upper", "(s.upper)"},
+		{"s.upper(x)", "(s.upper)(x)"},
+		{"a.b.c", "((a.b).c)"},
+		{"a.b[0]", "((a.b)[0])"},
+		{"-a.b", "(-(a.b))"},
+		{"a.b + c.d", "((a.b)+(c.d))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { 0 => "zero", _ => "other" }`, `match x {0 => "zero", _ => "other"}`},
+		{`match (x) { -1 => a, 2.5 => b, true => c, "s" => d, }`, `match x {(-1) => a, 2.5 => b, true => c, "s" => d}`},
+		{`match (xs) { [] => 0, [x, ...rest] => x + 1 }`, `match xs {[] => 0, [x,...rest] => (x+1)}`},
+		{`match (s) { {type: "circle", r} => r * r, {type} => type }`, `match s {{type:"circle",r} => (r*r), {type} => type}`},
+		{`match (n) { x if x > 0 => x, _ => 0 }`, `match n {x if (x>0) => x, _ => 0}`},
+		{`match (n) { 0 => { let y = 1; y } _ => { 2 } }`, `match n {0 => let y = 1;y, _ => 2}`},
+		{`let f = match (n) { _ => n } + 1;`, `let f = (match n {_ => n}+1);`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestMatchArms(t *testing.T) {
+	l := lexer.New(`match (v) { [x, 1] if x => x, {a: "b"} => 2 }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	match, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("expression is not *ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, match.Subject, "v") {
+		return
+	}
+	if len(match.Arms) != 2 {
+		t.Fatalf("wrong number of arms. got=%d", len(match.Arms))
+	}
+
+	array, ok := match.Arms[0].Pattern.(*ast.ArrayPattern)
+	if !ok {
+		t.Fatalf("pattern is not *ast.ArrayPattern. got=%T", match.Arms[0].Pattern)
+	}
+	literal, ok := array.Elements[1].(*ast.LiteralPattern)
+	if !ok {
+		t.Fatalf("element is not *ast.LiteralPattern. got=%T", array.Elements[1])
+	}
+	testLiteralExpression(t, literal.Value, 1)
+	testIdentifier(t, match.Arms[0].Guard, "x")
+
+	hash, ok := match.Arms[1].Pattern.(*ast.HashPattern)
+	if !ok {
+		t.Fatalf("pattern is not *ast.HashPattern. got=%T", match.Arms[1].Pattern)
+	}
+	if _, ok := hash.Pairs[0].Value.(*ast.LiteralPattern); !ok {
+		t.Fatalf("hash pattern value is not *ast.LiteralPattern. got=%T", hash.Pairs[0].Value)
+	}
+	if match.Arms[1].Guard != nil {
+		t.Errorf("guard is not nil. got=%s", match.Arms[1].Guard)
+	}
+	testLiteralExpression(t, match.Arms[1].Body, 2)
+}
+
+func TestInvalidMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`match (x) { 0 => 1 _ => 2 }`, "1:20: expected token = ',' , got = 'IDENT'"},
+		{`match (x) { 0 -> 1 }`, "1:15: expected token = '=>' , got = '-'"},
+		{`match (x) { -a => 1 }`, "1:14: expected a number after '-' in pattern, got = 'IDENT'"},
+		{`match x { _ => 1 }`, "1:7: expected token = '(' , got = 'IDENT'"},
+		// literals are only patterns in match arms
+		{`let [0, a] = x;`, "1:6: expected a name or a destructuring pattern, got = 'INT'"},
+		{`match (x) { _ => fn(1) { 1 } }`, "1:21: expected a name or a destructuring pattern, got = 'INT'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		low      interface{}
+		high     interface{}
+	}{
+		{"xs[1:2]", "(xs[1:2])", 1, 2},
+		{"xs[:2]", "(xs[:2])", nil, 2},
+		{"xs[1:]", "(xs[1:])", 1, nil},
+		{"xs[:]", "(xs[:])", nil, nil},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		slice, ok := stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("expression is not *ast.SliceExpression. got=%T", stmt.Expression)
+		}
+		if !testIdentifier(t, slice.Left, "xs") {
+			return
+		}
+		if tt.low == nil && slice.Low != nil {
+			t.Errorf("slice.Low is not nil. got=%s", slice.Low)
+		}
+		if tt.low != nil {
+			testLiteralExpression(t, slice.Low, tt.low)
+		}
+		if tt.high == nil && slice.High != nil {
+			t.Errorf("slice.High is not nil. got=%s", slice.High)
+		}
+		if tt.high != nil {
+			testLiteralExpression(t, slice.High, tt.high)
+		}
+	}
+}
+
+func TestThrowAndTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "oops";`, `throw "oops";`},
+		{`throw {"kind": "ValueError"}`, `throw {"kind":"ValueError"};`},
+		{`try { f() } catch (e) { e["message"] }`, `try f() catch(e) (e["message"])`},
+		{`try { f() } finally { g() }`, `try f() finally g()`},
+		{`try { f() } catch (e) { 1 } finally { g() }`, `try f() catch(e) 1 finally g()`},
+		{`try { f() } catch (e) { 1 };`, `try f() catch(e) 1`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New(`try { 1 } catch (err) { 2 }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.TryStatement)
+	if !ok {
+		t.Fatalf("statement is not *ast.TryStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Param.Value != "err" {
+		t.Errorf("catch parameter wrong. got=%q", stmt.Param.Value)
+	}
+	if stmt.Finally != nil {
+		t.Errorf("finally block is not nil. got=%s", stmt.Finally)
+	}
+	testLiteralExpression(t, stmt.Body.Statements[0].(*ast.ExpressionStatement).Expression, 1)
+	testLiteralExpression(t, stmt.Catch.Statements[0].(*ast.ExpressionStatement).Expression, 2)
+}
+
+func TestInvalidTryStatements(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`try { f() }`, "1:1: try without catch or finally"},
+		{`try { f() } catch { g() }`, "1:19: expected token = '(' , got = '{'"},
+		{`try { f() } catch (1) { g() }`, "1:20: expected token = 'IDENT' , got = 'INT'"},
+		{`try f() catch (e) { g() }`, "1:5: expected token = '{' , got = 'IDENT'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0].Error() != tt.expectedError {
+			t.Errorf("input %q: expected error %q, got=%v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
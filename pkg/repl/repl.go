@@ -3,6 +3,8 @@ package repl
 import (
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
 	"github.com/chzyer/readline"
 	"github.com/sbrki/monkey/pkg/evaluator"
@@ -29,6 +31,7 @@ func Start(in io.ReadCloser, out io.Writer) {
 	defer term.Close()
 
 	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
 	for {
 		line, err := term.Readline()
 		if err != nil { // EOF
@@ -41,11 +44,19 @@ func Start(in io.ReadCloser, out io.Writer) {
 		program := p.ParseProgram()
 
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, expansionErr := evaluator.ExpandMacros(program, macroEnv)
+		if expansionErr != nil {
+			io.WriteString(out, expansionErr.Inspect())
+			io.WriteString(out, "\n")
+			continue
+		}
+
+		evaluated := evaluator.Eval(expanded, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -53,9 +64,49 @@ func Start(in io.ReadCloser, out io.Writer) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+// Run evaluates the program in the file at path, printing its syntax
+// errors or the error it fails with to out. It reports whether the program
+// ran successfully.
+func Run(path string, out io.Writer) bool {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		io.WriteString(out, err.Error()+"\n")
+		return false
+	}
+
+	p := parser.New(lexer.NewFile(path, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(out, string(src), p.Errors())
+		return false
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, expansionErr := evaluator.ExpandMacros(program, macroEnv)
+	if expansionErr != nil {
+		io.WriteString(out, expansionErr.Inspect()+"\n")
+		return false
+	}
+
+	evaluated := evaluator.EvalFile(path, expanded, object.NewEnvironment())
+	if evaluated != nil && evaluated.Type() == object.ERROR_OBJ {
+		io.WriteString(out, evaluated.Inspect()+"\n")
+		return false
+	}
+	return true
+}
+
+// printParserErrors prints errors in the order they appear in src, each
+// followed by an excerpt of src showing where it is.
+func printParserErrors(out io.Writer, src string, errors parser.ErrorList) {
+	errors.RemoveDuplicates()
+
 	io.WriteString(out, "Whoops! We ran into some monkey business when parsing the input!\n")
-	for idx, msg := range errors {
-		io.WriteString(out, fmt.Sprintf("[%d]\t%s\n", idx+1, msg))
+	for idx, err := range errors {
+		io.WriteString(out, fmt.Sprintf("[%d]\t%s\n", idx+1, err))
+		if excerpt := err.Excerpt(src); excerpt != "" {
+			io.WriteString(out, "\t"+strings.ReplaceAll(excerpt, "\n", "\n\t")+"\n")
+		}
 	}
 }
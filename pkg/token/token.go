@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
@@ -7,13 +9,25 @@ const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 
+	// Trivia
+	LINE_COMMENT  = "LINE_COMMENT"
+	BLOCK_COMMENT = "BLOCK_COMMENT"
+
 	// Identifiers + literals
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
+	// Parts of an interpolated string "a${x}b${y}c": TEMPLATE_HEAD is
+	// "a${, TEMPLATE_MIDDLE is }b${ and TEMPLATE_TAIL is }c"
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	// Operators
 	ASSIGN   = "="
+	ARROW    = "=>"
 	EQ       = "=="
 	PLUS     = "+"
 	MINUS    = "-"
@@ -21,14 +35,32 @@ const (
 	NOT_EQ   = "!="
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
-	LT = "<"
-	GT = ">"
+	AMPERSAND = "&"
+	PIPE      = "|"
+	CARET     = "^"
+	TILDE     = "~"
+	LSHIFT    = "<<"
+	RSHIFT    = ">>"
+
+	AND = "&&"
+	OR  = "||"
+
+	PIPELINE = "|>"
 
 	// Delimiters
 	COMMA     = ","
+	DOT       = "."
+	ELLIPSIS  = "..."
 	SEMICOLON = ";"
-	COLON = ":"
+	COLON     = ":"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -45,21 +77,90 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	MACRO    = "MACRO"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+	MATCH    = "MATCH"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 )
 
+// Position describes a location in the source.
+type Position struct {
+	Filename string // may be empty
+	Offset   int    // byte offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // column number, starting at 1
+}
+
+// IsValid reports whether the position was set by the lexer.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns the position in the form "file:line:column",
+// "line:column" (no file name) or "-" (invalid position).
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	s := fmt.Sprintf("%d:%d", p.Line, p.Column)
+	if p.Filename != "" {
+		s = p.Filename + ":" + s
+	}
+	return s
+}
+
+// Trivia is source text that is irrelevant to the parser but kept
+// alongside the tokens, so that tools can reproduce it. At the moment
+// the only trivia are comments.
+type Trivia struct {
+	Type TokenType // LINE_COMMENT or BLOCK_COMMENT
+	Text string    // the comment text, including the comment markers
+	Pos  Position
+	End  Position
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the token
+
+	// LeadingTrivia holds the comments between the previous token's
+	// trailing trivia and this token.
+	LeadingTrivia []Trivia
+	// TrailingTrivia holds the comments following this token up to the
+	// end of its line.
+	TrailingTrivia []Trivia
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"macro":    MACRO,
+	"import":   IMPORT,
+	"export":   EXPORT,
+	"match":    MATCH,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
 }
 
 func LookupIdent(ident string) TokenType {